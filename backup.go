@@ -0,0 +1,284 @@
+package medtronic
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// BackupVersion is the version of the Backup format
+// produced by this package.
+// Version 2 added HasPumpSettings.
+const BackupVersion = 2
+
+// Backup represents a snapshot of the pump's settings.
+type Backup struct {
+	Version         int
+	Time            time.Time
+	Clock           time.Time // the time according to the pump's clock at Time
+	Model           string
+	PumpID          string
+	FirmwareVersion string
+	// HasPumpSettings is false for backups that contain only schedules,
+	// units, and insulin action (such as those converted from a Nightscout profile).
+	// Settings other than InsulinAction are meaningful only if it is true.
+	HasPumpSettings bool
+	Settings        SettingsInfo
+	CarbUnits       CarbUnitsType
+	GlucoseUnits    GlucoseUnitsType
+	BasalRates      BasalRateSchedule
+	BasalPatternA   BasalRateSchedule
+	BasalPatternB   BasalRateSchedule
+	CarbRatios      CarbRatioSchedule
+	Sensitivities   InsulinSensitivitySchedule
+	Targets         GlucoseTargetSchedule
+}
+
+// Backup returns a snapshot of the pump's settings.
+func (pump *Pump) Backup() Backup {
+	b := Backup{
+		Version:         BackupVersion,
+		Time:            time.Now(),
+		Clock:           pump.Clock(),
+		Model:           pump.Model(),
+		PumpID:          pump.PumpID(),
+		FirmwareVersion: pump.FirmwareVersion(),
+		HasPumpSettings: true,
+		Settings:        pump.Settings(),
+		CarbUnits:       pump.CarbUnits(),
+		GlucoseUnits:    pump.GlucoseUnits(),
+		BasalRates:      pump.BasalRates(),
+		BasalPatternA:   pump.BasalPatternA(),
+		BasalPatternB:   pump.BasalPatternB(),
+		CarbRatios:      pump.CarbRatios(),
+		Sensitivities:   pump.InsulinSensitivities(),
+		Targets:         pump.GlucoseTargets(),
+	}
+	if pump.Error() != nil {
+		return Backup{}
+	}
+	return b
+}
+
+// restorePlan holds the values that Restore will write to a pump,
+// after rounding to the pump's resolution.
+// Nil values are not restored.
+type restorePlan struct {
+	maxBolus *Insulin
+	maxBasal *Insulin
+	basal    [3]BasalRateSchedule // indexed by basal pattern
+	pattern  *int
+	notes    []string
+}
+
+func (p *restorePlan) note(format string, args ...interface{}) {
+	p.notes = append(p.notes, fmt.Sprintf(format, args...))
+}
+
+var basalPatternNames = [3]string{"standard basal", "basal pattern A", "basal pattern B"}
+
+func (b Backup) basalSchedules() [3]BasalRateSchedule {
+	return [3]BasalRateSchedule{b.BasalRates, b.BasalPatternA, b.BasalPatternB}
+}
+
+// planRestore determines which settings in a backup can be restored
+// to a pump of the given family, and how they will be rounded.
+func planRestore(b Backup, family Family) restorePlan {
+	var p restorePlan
+	if b.HasPumpSettings {
+		planMaxBolus(&p, b.Settings.MaxBolus)
+		planMaxBasal(&p, b.Settings.MaxBasal)
+	} else {
+		p.note("skipping max bolus: not in backup")
+		p.note("skipping max basal rate: not in backup")
+	}
+	for i, s := range b.basalSchedules() {
+		planBasalSchedule(&p, i, s, family)
+	}
+	if !b.HasPumpSettings {
+		p.note("skipping selected basal pattern: not in backup")
+		return p
+	}
+	n := b.Settings.SelectedPattern
+	if 0 <= n && n < len(p.basal) && p.basal[n] != nil {
+		p.pattern = &n
+	} else {
+		p.note("skipping selected basal pattern %d", n)
+	}
+	return p
+}
+
+func planMaxBolus(p *restorePlan, amount Insulin) {
+	strokes, err := encodeMaxBolus(amount)
+	if err != nil {
+		p.note("skipping max bolus: %v", err)
+		return
+	}
+	actual := Insulin(strokes) * milliUnitsPerStroke(22)
+	if actual != amount {
+		p.note("max bolus will be rounded from %v to %v", amount, actual)
+	}
+	p.maxBolus = &actual
+}
+
+func planMaxBasal(p *restorePlan, rate Insulin) {
+	strokes, err := encodeMaxBasal(rate)
+	if err != nil {
+		p.note("skipping max basal rate: %v", err)
+		return
+	}
+	actual := Insulin(strokes) * milliUnitsPerStroke(23)
+	if actual != rate {
+		p.note("max basal rate will be rounded from %v to %v", rate, actual)
+	}
+	p.maxBasal = &actual
+}
+
+func planBasalSchedule(p *restorePlan, pattern int, s BasalRateSchedule, family Family) {
+	name := basalPatternNames[pattern]
+	if len(s) == 0 {
+		p.note("skipping %s: schedule is empty", name)
+		return
+	}
+	data, err := encodeBasalRateSchedule(s, family)
+	if err != nil {
+		p.note("skipping %s: %v", name, err)
+		return
+	}
+	actual := decodeBasalRateSchedule(data)
+	for i, v := range actual {
+		if v.Rate != s[i].Rate {
+			p.note("%s rate at %v will be rounded from %v to %v", name, v.Start, s[i].Rate, v.Rate)
+		}
+	}
+	p.basal[pattern] = actual
+}
+
+// Restore writes the settings in a backup to the pump.
+// Settings that the pump cannot represent are skipped,
+// and values are rounded to the pump's resolution.
+// The resulting settings are checked with LintSettings before
+// anything is written, and nothing is written if there are errors.
+// The bolus wizard settings (carb ratios, insulin sensitivities,
+// glucose targets, and insulin action) are not restored,
+// since there are no documented commands to change them.
+// It returns a list of notes describing what was skipped or rounded.
+func (pump *Pump) Restore(b Backup) []string {
+	if b.Version != BackupVersion {
+		pump.SetError(fmt.Errorf("unsupported backup version %d", b.Version))
+		return nil
+	}
+	model := pump.Model()
+	family := pump.Family()
+	current := pump.Settings()
+	wizard := pump.BolusWizardConfig()
+	carbUnits := pump.CarbUnits()
+	glucoseUnits := pump.GlucoseUnits()
+	if pump.Error() != nil {
+		return nil
+	}
+	p := planRestore(b, family)
+	noteUnrestoredWizard(&p, b, wizard, carbUnits, glucoseUnits)
+	if model != b.Model {
+		p.note("restoring backup from model %s pump to model %s pump", b.Model, model)
+	}
+	if b.HasPumpSettings {
+		noteUnrestored(&p, b.Settings, current)
+	}
+	issues := LintSettings(p.settings(current, wizard), family)
+	for _, i := range issues {
+		if i.Warning {
+			p.note("%v", i)
+		}
+	}
+	for _, n := range p.notes {
+		log.Print(n)
+	}
+	if err := issues.Err(); err != nil {
+		pump.SetError(err)
+		return p.notes
+	}
+	if p.maxBasal != nil {
+		pump.SetMaxBasal(*p.maxBasal)
+	}
+	if p.maxBolus != nil {
+		pump.SetMaxBolus(*p.maxBolus)
+	}
+	if p.basal[0] != nil {
+		pump.SetBasalRates(p.basal[0])
+	}
+	if p.basal[1] != nil {
+		pump.SetBasalPatternA(p.basal[1])
+	}
+	if p.basal[2] != nil {
+		pump.SetBasalPatternB(p.basal[2])
+	}
+	if p.pattern != nil {
+		pump.SelectBasalPattern(*p.pattern)
+	}
+	return p.notes
+}
+
+// settings returns the settings that will be in effect after the plan
+// has been carried out on a pump with the given current settings
+// and bolus wizard configuration.
+func (p *restorePlan) settings(current SettingsInfo, wizard BolusWizardConfig) Backup {
+	b := Backup{
+		Settings:      current,
+		BasalRates:    p.basal[0],
+		BasalPatternA: p.basal[1],
+		BasalPatternB: p.basal[2],
+		CarbRatios:    wizard.Ratios,
+		Sensitivities: wizard.Sensitivities,
+		Targets:       wizard.Targets,
+	}
+	if p.maxBasal != nil {
+		b.Settings.MaxBasal = *p.maxBasal
+	}
+	if p.maxBolus != nil {
+		b.Settings.MaxBolus = *p.maxBolus
+	}
+	return b
+}
+
+// noteUnrestoredWizard records differences in the units
+// and bolus wizard configuration, which are not restored.
+func noteUnrestoredWizard(p *restorePlan, b Backup, wizard BolusWizardConfig, carbUnits CarbUnitsType, glucoseUnits GlucoseUnitsType) {
+	if b.CarbUnits != 0 && b.CarbUnits != carbUnits {
+		p.note("carb units (%v in backup, %v on pump) are not restored", b.CarbUnits, carbUnits)
+	}
+	if b.GlucoseUnits != 0 && b.GlucoseUnits != glucoseUnits {
+		p.note("glucose units (%v in backup, %v on pump) are not restored", b.GlucoseUnits, glucoseUnits)
+	}
+	if b.CarbRatios != nil && !reflect.DeepEqual(b.CarbRatios, wizard.Ratios) {
+		p.note("carb ratios differ from the pump's and are not restored")
+	}
+	if b.Sensitivities != nil && !reflect.DeepEqual(b.Sensitivities, wizard.Sensitivities) {
+		p.note("insulin sensitivities differ from the pump's and are not restored")
+	}
+	if b.Targets != nil && !reflect.DeepEqual(b.Targets, wizard.Targets) {
+		p.note("glucose targets differ from the pump's and are not restored")
+	}
+	action := time.Duration(wizard.InsulinAction)
+	if b.Settings.InsulinAction != 0 && b.Settings.InsulinAction != action {
+		p.note("insulin action (%v in backup, %v on pump) is not restored", b.Settings.InsulinAction, action)
+	}
+}
+
+// noteUnrestored records differences in settings
+// for which there is no command to change them.
+func noteUnrestored(p *restorePlan, backup SettingsInfo, current SettingsInfo) {
+	if backup.AutoOff != current.AutoOff {
+		p.note("auto-off duration (%v in backup, %v on pump) is not restored", backup.AutoOff, current.AutoOff)
+	}
+	if backup.InsulinConcentration != current.InsulinConcentration {
+		p.note("insulin concentration (U%d in backup, U%d on pump) is not restored", backup.InsulinConcentration, current.InsulinConcentration)
+	}
+	if backup.RFEnabled != current.RFEnabled {
+		p.note("RF enabled setting (%v in backup, %v on pump) is not restored", backup.RFEnabled, current.RFEnabled)
+	}
+	if backup.TempBasalType != current.TempBasalType {
+		p.note("temp basal type (%v in backup, %v on pump) is not restored", backup.TempBasalType, current.TempBasalType)
+	}
+}
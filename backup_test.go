@@ -0,0 +1,160 @@
+package medtronic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testBackup() Backup {
+	return Backup{
+		Version:         BackupVersion,
+		Model:           "523",
+		HasPumpSettings: true,
+		Settings: SettingsInfo{
+			AutoOff:              0,
+			InsulinAction:        3 * time.Hour,
+			InsulinConcentration: 100,
+			MaxBolus:             10000,
+			MaxBasal:             2000,
+			RFEnabled:            true,
+			TempBasalType:        Absolute,
+			SelectedPattern:      1,
+		},
+		CarbUnits:    Grams,
+		GlucoseUnits: MgPerDeciLiter,
+		BasalRates: BasalRateSchedule{
+			{parseTD("00:00"), 850},
+			{parseTD("06:00"), 1000},
+		},
+		BasalPatternA: BasalRateSchedule{
+			{parseTD("00:00"), 500},
+		},
+		CarbRatios:    CarbRatioSchedule{{parseTD("00:00"), 65, Grams}},
+		Sensitivities: InsulinSensitivitySchedule{{parseTD("00:00"), 45, MgPerDeciLiter}},
+		Targets:       GlucoseTargetSchedule{{parseTD("00:00"), 100, 120, MgPerDeciLiter}},
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	b := testBackup()
+	p := planRestore(b, 23)
+	if p.maxBolus == nil || *p.maxBolus != 10000 {
+		t.Errorf("max bolus == %v, want 10.000", p.maxBolus)
+	}
+	if p.maxBasal == nil || *p.maxBasal != 2000 {
+		t.Errorf("max basal == %v, want 2.000", p.maxBasal)
+	}
+	want := [3]BasalRateSchedule{b.BasalRates, b.BasalPatternA, nil}
+	if !reflect.DeepEqual(p.basal, want) {
+		t.Errorf("basal schedules == %+v, want %+v", p.basal, want)
+	}
+	if p.pattern == nil || *p.pattern != 1 {
+		t.Errorf("selected pattern == %v, want 1", p.pattern)
+	}
+	notes := []string{"skipping basal pattern B: schedule is empty"}
+	if !reflect.DeepEqual(p.notes, notes) {
+		t.Errorf("notes == %q, want %q", p.notes, notes)
+	}
+}
+
+func TestPlanRestoreRounding(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	b := testBackup()
+	b.Settings.SelectedPattern = 2
+	b.BasalRates[1].Rate = 1010
+	p := planRestore(b, 12)
+	notes := []string{
+		"standard basal rate at 06:00 will be rounded from 1.010 to 1.000",
+		"skipping basal pattern B: schedule is empty",
+		"skipping selected basal pattern 2",
+	}
+	if !reflect.DeepEqual(p.notes, notes) {
+		t.Errorf("notes ==\n%q\nwant\n%q", p.notes, notes)
+	}
+	if p.pattern != nil {
+		t.Errorf("planRestore did not skip unrestorable settings: %+v", p)
+	}
+}
+
+func TestPlanRestoreProfile(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	b := testBackup()
+	b.HasPumpSettings = false
+	p := planRestore(b, 23)
+	notes := []string{
+		"skipping max bolus: not in backup",
+		"skipping max basal rate: not in backup",
+		"skipping basal pattern B: schedule is empty",
+		"skipping selected basal pattern: not in backup",
+	}
+	if !reflect.DeepEqual(p.notes, notes) {
+		t.Errorf("notes ==\n%q\nwant\n%q", p.notes, notes)
+	}
+	if p.maxBolus != nil || p.maxBasal != nil || p.pattern != nil {
+		t.Errorf("planRestore did not skip settings missing from backup: %+v", p)
+	}
+}
+
+func TestNoteUnrestoredWizard(t *testing.T) {
+	b := testBackup()
+	wizard := BolusWizardConfig{
+		Ratios:        b.CarbRatios,
+		Sensitivities: b.Sensitivities,
+		Targets:       b.Targets,
+		InsulinAction: Duration(b.Settings.InsulinAction),
+	}
+	var p restorePlan
+	noteUnrestoredWizard(&p, b, wizard, Grams, MgPerDeciLiter)
+	if p.notes != nil {
+		t.Errorf("noteUnrestoredWizard reported matching settings: %q", p.notes)
+	}
+	wizard.Targets = GlucoseTargetSchedule{{parseTD("00:00"), 5500, 6700, MMolPerLiter}}
+	wizard.InsulinAction = Duration(4 * time.Hour)
+	noteUnrestoredWizard(&p, b, wizard, Grams, MMolPerLiter)
+	notes := []string{
+		"glucose units (mg/dL in backup, μmol/L on pump) are not restored",
+		"glucose targets differ from the pump's and are not restored",
+		"insulin action (3h0m0s in backup, 4h0m0s on pump) is not restored",
+	}
+	if !reflect.DeepEqual(p.notes, notes) {
+		t.Errorf("notes ==\n%q\nwant\n%q", p.notes, notes)
+	}
+	// Settings missing from the backup are not compared.
+	p = restorePlan{}
+	noteUnrestoredWizard(&p, Backup{}, wizard, Grams, MMolPerLiter)
+	if p.notes != nil {
+		t.Errorf("noteUnrestoredWizard reported settings missing from backup: %q", p.notes)
+	}
+}
+
+func TestBackupJSON(t *testing.T) {
+	b := testBackup()
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u Backup
+	err = json.Unmarshal(data, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u, b) {
+		t.Errorf("json.Unmarshal(%s) == %+v, want %+v", data, u, b)
+	}
+}
+
+func TestBackupJSONVersion1(t *testing.T) {
+	var b Backup
+	err := json.Unmarshal([]byte(`{"Version":1,"Model":"523"}`), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != BackupVersion || !b.HasPumpSettings {
+		t.Errorf("version 1 backup unmarshaled as %+v", b)
+	}
+}
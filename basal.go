@@ -105,7 +105,7 @@ func (pump *Pump) setBasalSchedule(cmd Command, s BasalRateSchedule) {
 		pump.SetError(err)
 		return
 	}
-	pump.ExtendedRequest(setBasalRates, data...)
+	pump.ExtendedRequest(cmd, data...)
 }
 
 // SetBasalRates sets the pump's basal rate schedule.
@@ -123,6 +123,16 @@ func (pump *Pump) SetBasalPatternB(s BasalRateSchedule) {
 	pump.setBasalSchedule(setBasalPatternB, s)
 }
 
+// SelectBasalPattern selects the pump's active basal pattern
+// (0 for the standard schedule, 1 for pattern A, 2 for pattern B).
+func (pump *Pump) SelectBasalPattern(n int) {
+	if n < 0 || 2 < n {
+		pump.SetError(fmt.Errorf("basal pattern (%d) must be 0, 1, or 2", n))
+		return
+	}
+	pump.Execute(selectBasalPattern, byte(n))
+}
+
 func encodeBasalRate(kind string, rate Insulin, family Family) (uint16, error) {
 	if rate < 0 {
 		return 0, fmt.Errorf("%s rate (%d) is negative", kind, rate)
@@ -0,0 +1,188 @@
+package medtronic
+
+import (
+	"time"
+)
+
+// BasalReason describes why a basal rate was in effect.
+type BasalReason string
+
+const (
+	// BasalScheduled means the rate was from the active basal schedule.
+	BasalScheduled BasalReason = "scheduled"
+	// BasalTemp means the rate was from a temporary basal.
+	BasalTemp BasalReason = "temp"
+	// BasalSuspended means delivery was suspended.
+	BasalSuspended BasalReason = "suspended"
+	// BasalRewound means delivery was stopped between a rewind and a prime.
+	BasalRewound BasalReason = "rewound"
+)
+
+// BasalSegment represents a basal rate that was delivered
+// over the interval [Start, End).
+type BasalSegment struct {
+	Start  time.Time
+	End    time.Time
+	Rate   Insulin
+	Reason BasalReason
+}
+
+// basalState tracks the pump's basal delivery while walking history records.
+type basalState struct {
+	scheduled      Insulin
+	scheduledKnown bool
+
+	temp    *TempBasalRecord
+	tempEnd time.Time
+
+	suspended bool
+	rewound   bool
+}
+
+// rate returns the current basal rate and the reason for it,
+// or false if it cannot be determined.
+func (s basalState) rate() (Insulin, BasalReason, bool) {
+	switch {
+	case s.rewound:
+		return 0, BasalRewound, true
+	case s.suspended:
+		return 0, BasalSuspended, true
+	case s.temp != nil && s.temp.Type == Absolute:
+		return s.temp.Value.(Insulin), BasalTemp, true
+	case s.temp != nil:
+		if !s.scheduledKnown {
+			return 0, "", false
+		}
+		return s.scheduled * Insulin(s.temp.Value.(int)) / 100, BasalTemp, true
+	case s.scheduledKnown:
+		return s.scheduled, BasalScheduled, true
+	}
+	return 0, "", false
+}
+
+// basalTimeline accumulates segments as the basal state changes.
+type basalTimeline struct {
+	state    basalState
+	start    time.Time
+	segments []BasalSegment
+}
+
+// advance ends the current segment at time t.
+func (tl *basalTimeline) advance(t time.Time) {
+	if !tl.start.IsZero() && t.After(tl.start) {
+		if rate, reason, ok := tl.state.rate(); ok {
+			tl.add(BasalSegment{Start: tl.start, End: t, Rate: rate, Reason: reason})
+		}
+	}
+	tl.start = t
+}
+
+// add appends a segment, merging it with the previous one if possible.
+func (tl *basalTimeline) add(seg BasalSegment) {
+	n := len(tl.segments)
+	if n != 0 {
+		prev := &tl.segments[n-1]
+		if prev.End.Equal(seg.Start) && prev.Rate == seg.Rate && prev.Reason == seg.Reason {
+			prev.End = seg.End
+			return
+		}
+	}
+	tl.segments = append(tl.segments, seg)
+}
+
+// expireTemp ends a temporary basal whose duration has elapsed by time t.
+func (tl *basalTimeline) expireTemp(t time.Time) {
+	if tl.state.temp != nil && !tl.state.tempEnd.After(t) {
+		tl.advance(tl.state.tempEnd)
+		tl.state.temp = nil
+	}
+}
+
+// BasalTimeline reconstructs the basal rates that were delivered,
+// from history records in reverse chronological order.
+// The result is in chronological order, and adjacent segments
+// with the same rate and reason are merged.
+//
+// The scheduled rate is taken from BasalProfileStart records,
+// which the pump writes whenever the scheduled rate changes.
+// After a ChangeBasalPattern record, the scheduled rate is unknown
+// until the next BasalProfileStart record, so there may be gaps
+// in the timeline where the delivered rate cannot be determined.
+// The timeline is broken wherever the pump's clock was changed;
+// use NormalizeHistory first to get a continuous timeline.
+// Other records that are out of chronological order are ignored.
+// The final segment ends at the time of the most recent record.
+func BasalTimeline(records History) []BasalSegment {
+	return basalTimelineUntil(records, time.Time{})
+}
+
+// basalTimelineUntil implements BasalTimeline, but extends the final segment
+// until the given time if it is later than the most recent record.
+func basalTimelineUntil(records History, end time.Time) []BasalSegment {
+	history := make(History, len(records))
+	copy(history, records)
+	ReverseHistory(history)
+	tl := &basalTimeline{}
+	// last is reset at clock changes; latest is not.
+	var last, latest time.Time
+	for i, r := range history {
+		if r.Time.IsZero() || isDailyTotal(r) || r.Time.Before(last) {
+			continue
+		}
+		last = r.Time
+		latest = r.Time
+		tl.expireTemp(r.Time)
+		switch r.Type() {
+		case BasalProfileStart:
+			tl.advance(r.Time)
+			tl.state.scheduled = r.Info.(BasalProfileStartRecord).BasalRate.Rate
+			tl.state.scheduledKnown = true
+		case ChangeBasalPattern:
+			tl.advance(r.Time)
+			tl.state.scheduledKnown = false
+		case TempBasalRate:
+			if i+1 >= len(history) || history[i+1].Type() != TempBasalDuration {
+				continue
+			}
+			tl.advance(r.Time)
+			d := time.Duration(history[i+1].Info.(Duration))
+			if d == 0 {
+				// A zero duration cancels the current temporary basal.
+				tl.state.temp = nil
+				continue
+			}
+			tb := r.Info.(TempBasalRecord)
+			tl.state.temp = &tb
+			tl.state.tempEnd = r.Time.Add(d)
+		case SuspendPump:
+			tl.advance(r.Time)
+			tl.state.suspended = true
+			// The pump cancels a temporary basal when it is suspended.
+			tl.state.temp = nil
+		case ResumePump:
+			tl.advance(r.Time)
+			tl.state.suspended = false
+			tl.state.rewound = false
+		case Rewind:
+			tl.advance(r.Time)
+			tl.state.rewound = true
+		case Prime:
+			tl.advance(r.Time)
+			tl.state.rewound = false
+		case ChangeTime:
+			// Don't let a segment span a change to the pump's clock.
+			tl.advance(r.Time)
+			tl.start = time.Time{}
+			last = time.Time{}
+		}
+	}
+	if latest.IsZero() {
+		return nil
+	}
+	if end.Before(last) {
+		end = last
+	}
+	tl.expireTemp(end)
+	tl.advance(end)
+	return tl.segments
+}
@@ -0,0 +1,93 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func profileStart(ts string, start string, rate Insulin) HistoryRecord {
+	return testDose(BasalProfileStart, ts, BasalProfileStartRecord{
+		BasalRate: BasalRate{Start: parseTD(start), Rate: rate},
+	})
+}
+
+func TestBasalTimeline(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testRecord(Bolus, "2020-03-10T20:00:00"),
+		profileStart("2020-03-10T19:00:00", "19:00", 1100),
+		// The scheduled rate is unknown until the next profile start.
+		testDose(ChangeBasalPattern, "2020-03-10T18:30:00", 1),
+		testRecord(Prime, "2020-03-10T18:10:00"),
+		testRecord(Rewind, "2020-03-10T18:00:00"),
+		testRecord(ResumePump, "2020-03-10T17:30:00"),
+		// Cancels the temporary basal.
+		testRecord(SuspendPump, "2020-03-10T17:00:00"),
+		testDose(TempBasalDuration, "2020-03-10T16:30:00", Duration(2*time.Hour)),
+		testDose(TempBasalRate, "2020-03-10T16:30:00", TempBasalRecord{Type: Absolute, Value: Insulin(3000)}),
+		// Cancelled early.
+		testDose(TempBasalDuration, "2020-03-10T16:00:00", Duration(0)),
+		testDose(TempBasalRate, "2020-03-10T16:00:00", TempBasalRecord{Type: Absolute, Value: Insulin(0)}),
+		testDose(TempBasalDuration, "2020-03-10T15:00:00", Duration(2*time.Hour)),
+		testDose(TempBasalRate, "2020-03-10T15:00:00", TempBasalRecord{Type: Absolute, Value: Insulin(2500)}),
+		// Percent temp across a schedule change.
+		profileStart("2020-03-10T14:00:00", "14:00", 1200),
+		testDose(TempBasalDuration, "2020-03-10T13:30:00", Duration(time.Hour)),
+		testDose(TempBasalRate, "2020-03-10T13:30:00", TempBasalRecord{Type: Percent, Value: 50}),
+		profileStart("2020-03-10T12:00:00", "12:00", 1000),
+		// Before the scheduled rate is known.
+		testRecord(Bolus, "2020-03-10T11:00:00"),
+	}
+	segment := func(start, end string, rate Insulin, reason BasalReason) BasalSegment {
+		return BasalSegment{Start: parseTime(start), End: parseTime(end), Rate: rate, Reason: reason}
+	}
+	want := []BasalSegment{
+		segment("2020-03-10T12:00:00", "2020-03-10T13:30:00", 1000, BasalScheduled),
+		segment("2020-03-10T13:30:00", "2020-03-10T14:00:00", 500, BasalTemp),
+		segment("2020-03-10T14:00:00", "2020-03-10T14:30:00", 600, BasalTemp),
+		segment("2020-03-10T14:30:00", "2020-03-10T15:00:00", 1200, BasalScheduled),
+		segment("2020-03-10T15:00:00", "2020-03-10T16:00:00", 2500, BasalTemp),
+		segment("2020-03-10T16:00:00", "2020-03-10T16:30:00", 1200, BasalScheduled),
+		segment("2020-03-10T16:30:00", "2020-03-10T17:00:00", 3000, BasalTemp),
+		segment("2020-03-10T17:00:00", "2020-03-10T17:30:00", 0, BasalSuspended),
+		segment("2020-03-10T17:30:00", "2020-03-10T18:00:00", 1200, BasalScheduled),
+		segment("2020-03-10T18:00:00", "2020-03-10T18:10:00", 0, BasalRewound),
+		segment("2020-03-10T18:10:00", "2020-03-10T18:30:00", 1200, BasalScheduled),
+		segment("2020-03-10T19:00:00", "2020-03-10T20:00:00", 1100, BasalScheduled),
+	}
+	segments := BasalTimeline(records)
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("BasalTimeline() = %+v, want %+v", segments, want)
+	}
+}
+
+func TestBasalTimelineHistory(t *testing.T) {
+	segments := BasalTimeline(setupPumpHistory())
+	if len(segments) == 0 {
+		t.Fatal("BasalTimeline() returned no segments")
+	}
+	for i, seg := range segments {
+		if !seg.End.After(seg.Start) {
+			t.Errorf("segment %d is empty: %+v", i, seg)
+		}
+		if i > 0 && seg.Start.Before(segments[i-1].End) {
+			t.Errorf("segment %d overlaps previous segment: %+v", i, seg)
+		}
+	}
+}
+
+func TestBasalTimelineChangeTime(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testRecord(ChangeTime, "2020-03-10T13:00:00"),
+		profileStart("2020-03-10T12:00:00", "12:00", 1000),
+	}
+	want := []BasalSegment{
+		{Start: parseTime("2020-03-10T12:00:00"), End: parseTime("2020-03-10T13:00:00"), Rate: 1000, Reason: BasalScheduled},
+	}
+	segments := BasalTimeline(records)
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("BasalTimeline() = %+v, want %+v", segments, want)
+	}
+}
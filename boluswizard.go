@@ -0,0 +1,24 @@
+package medtronic
+
+const (
+	// Maximum number of entries in a carb ratio, insulin sensitivity,
+	// or glucose target schedule.
+	maxScheduleEntries = 8
+)
+
+// BolusWizardConfig returns the pump's bolus wizard configuration.
+func (pump *Pump) BolusWizardConfig() BolusWizardConfig {
+	ratios := pump.CarbRatios()
+	sensitivities := pump.InsulinSensitivities()
+	targets := pump.GlucoseTargets()
+	settings := pump.Settings()
+	if pump.Error() != nil {
+		return BolusWizardConfig{}
+	}
+	return BolusWizardConfig{
+		Ratios:        ratios,
+		Sensitivities: sensitivities,
+		Targets:       targets,
+		InsulinAction: Duration(settings.InsulinAction),
+	}
+}
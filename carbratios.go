@@ -1,6 +1,7 @@
 package medtronic
 
 import (
+	"fmt"
 	"log"
 	"time"
 )
@@ -35,6 +36,47 @@ func intToRatio(n int, u CarbUnitsType, family Family) Ratio {
 	return Ratio(n)
 }
 
+func ratioToInt(r Ratio, u CarbUnitsType, family Family) (int, error) {
+	if family > 22 {
+		// Use representation as-is.
+		return int(r), nil
+	}
+	// Convert to lower-resolution representation.
+	var res Ratio
+	switch u {
+	case Grams:
+		res = 10
+	case Exchanges:
+		res = 100
+	default:
+		return 0, fmt.Errorf("unknown carb unit %d", u)
+	}
+	actual := (r + res/2) / res
+	if actual*res != r {
+		log.Printf("rounding carb ratio from %d to %d", r, actual*res)
+	}
+	return int(actual), nil
+}
+
+// gramsPerUnit converts a carb ratio to grams/unit.
+func gramsPerUnit(r Ratio, u CarbUnitsType) float64 {
+	if r == 0 {
+		return 0
+	}
+	if u == Exchanges {
+		return gramsPerExchange / (float64(r) / 1000)
+	}
+	return float64(r) / 10
+}
+
+// formatRatio returns a human-readable representation of a carb ratio.
+func formatRatio(r Ratio, u CarbUnitsType) string {
+	if u == Exchanges {
+		return fmt.Sprintf("%.3f U/exch", float64(r)/1000)
+	}
+	return fmt.Sprintf("%.1f g/U", float64(r)/10)
+}
+
 // CarbRatioSchedule represents a carb ratio schedule.
 type CarbRatioSchedule []CarbRatio
 
@@ -68,7 +110,7 @@ func decodeCarbRatioSchedule(data []byte, units CarbUnitsType, family Family) Ca
 	return sched
 }
 
-// CarbRatios returns the pump's carb ratio schedule..
+// CarbRatios returns the pump's carb ratio schedule.
 func (pump *Pump) CarbRatios() CarbRatioSchedule {
 	data := pump.Execute(carbRatios)
 	if pump.Error() != nil {
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/ecc1/medtronic"
@@ -15,12 +16,22 @@ var (
 	numHours  = flag.Int("n", 6, "number of `hours` of history to get")
 	nsFlag    = flag.Bool("ns", false, "format as Nightscout entries")
 	sinceFlag = flag.String("s", "", "get history since the specified `time` in RFC3339 format")
+	csvFlag   = flag.Bool("csv", false, "format as CSV")
+	zoneFlag  = flag.String("tz", "", "convert CSV timestamps to the time `zone` with the given name")
+	bgFlag    = flag.String("bg", "mg/dL", "glucose `units` for CSV output (mg/dL or mmol/L)")
 )
 
 func main() {
 	flag.Parse()
 	var cutoff time.Time
+	var csvOptions medtronic.CSVOptions
 	var err error
+	if *csvFlag {
+		csvOptions, err = medtronic.ParseCSVOptions(*zoneFlag, *bgFlag, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	if *all {
 		log.Printf("retrieving entire CGM history")
 	} else if *sinceFlag != "" {
@@ -41,6 +52,11 @@ func main() {
 	if *nsFlag {
 		medtronic.ReverseCGMHistory(results)
 		fmt.Println(nightscout.JSON(medtronic.NightscoutEntries(results)))
+	} else if *csvFlag {
+		err = medtronic.WriteCGMHistoryCSV(os.Stdout, results, csvOptions)
+		if err != nil {
+			log.Print(err)
+		}
 	} else {
 		fmt.Println(nightscout.JSON(results))
 	}
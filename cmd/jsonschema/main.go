@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ecc1/medtronic"
+)
+
+func main() {
+	b, err := medtronic.JSONSchema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ecc1/medtronic"
+	"github.com/ecc1/nightscout"
 )
 
 type (
@@ -28,6 +30,7 @@ type (
 var (
 	// TODO: add per-command help
 	command = map[string]Command{
+		"backup":        cmd(backup),
 		"basal":         cmd(basal),
 		"battery":       cmd(battery),
 		"bolus":         cmd(bolus, "units"),
@@ -35,12 +38,16 @@ var (
 		"carbratios":    cmd(carbRatios),
 		"carbunits":     cmd(carbUnits),
 		"clock":         cmd(clock),
+		"consumables":   cmd(consumables),
+		"diff":          cmd(diff, "source", "arg"),
 		"execute":       cmdN(execute, "command", "arguments"),
 		"firmware":      cmd(firmware),
 		"glucoseunits":  cmd(glucoseUnits),
+		"lint":          cmd(lint),
 		"model":         cmd(model),
 		"pumpid":        cmd(pumpID),
 		"reservoir":     cmd(reservoir),
+		"restore":       cmd(restore, "file"),
 		"resume":        cmd(resume),
 		"rssi":          cmd(rssi),
 		"sensitivities": cmd(sensitivities),
@@ -54,6 +61,8 @@ var (
 		"targets":       cmd(targets),
 		"tempbasal":     cmd(tempBasal),
 		"wakeup":        cmd(wakeup),
+		"wizard":        cmd(wizard),
+		"wizardstats":   cmd(wizardStats),
 	}
 )
 
@@ -73,6 +82,10 @@ func cmdError(name string, msg string, err error) {
 
 // TODO: with argument to schedule progs, get schedule at that time
 
+func backup(pump *medtronic.Pump, _ Arguments) interface{} {
+	return pump.Backup()
+}
+
 func basal(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.BasalRates()
 }
@@ -140,6 +153,82 @@ func clock(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.Clock()
 }
 
+func consumables(pump *medtronic.Pump, _ Arguments) interface{} {
+	return pump.Consumables()
+}
+
+// diff compares the pump's settings to those in a backup file,
+// a Nightscout profile document, or another pump.
+func diff(pump *medtronic.Pump, args Arguments) interface{} {
+	source, err := args.String("source")
+	if err != nil {
+		diffUsage(err)
+	}
+	arg, err := args.String("arg")
+	if err != nil {
+		diffUsage(err)
+	}
+	var ref medtronic.Backup
+	switch source {
+	case "backup":
+		err = readJSON(arg, &ref)
+	case "nightscout":
+		ref, err = readProfile(arg)
+	case "pump":
+		ref = otherPumpSettings(pump, arg)
+	default:
+		err = fmt.Errorf("unknown source %q", source)
+	}
+	if err != nil {
+		diffUsage(err)
+	}
+	if pump.Error() != nil {
+		return nil
+	}
+	changes := medtronic.DiffSettings(ref, pump.Backup())
+	for _, c := range changes {
+		log.Print(c)
+	}
+	return changes
+}
+
+func diffUsage(err error) {
+	cmdError("diff", "(backup file.json | nightscout profile.json | pump ID)", err)
+}
+
+// readProfile reads a Nightscout profile document,
+// or an array of them as returned by the profile API,
+// in which case the first (most recent) one is used.
+func readProfile(file string) (medtronic.Backup, error) {
+	var profiles []nightscout.Profile
+	err := readJSON(file, &profiles)
+	if err != nil {
+		var p nightscout.Profile
+		err = readJSON(file, &p)
+		if err != nil {
+			return medtronic.Backup{}, err
+		}
+		profiles = append(profiles, p)
+	}
+	if len(profiles) == 0 {
+		return medtronic.Backup{}, fmt.Errorf("%s: no profiles found", file)
+	}
+	return medtronic.ProfileSettings(profiles[0])
+}
+
+// otherPumpSettings reads the settings of the pump with the given ID,
+// then directs subsequent commands back to the original pump.
+func otherPumpSettings(pump *medtronic.Pump, id string) medtronic.Backup {
+	orig := pump.PumpID()
+	if pump.Error() != nil {
+		return medtronic.Backup{}
+	}
+	defer pump.SelectPump(orig)
+	pump.SelectPump(id)
+	pump.Wakeup()
+	return pump.Backup()
+}
+
 func execute(pump *medtronic.Pump, args Arguments) interface{} {
 	c, err := strconv.ParseUint(args["command"].(string), 16, 8)
 	if err != nil {
@@ -174,6 +263,18 @@ func glucoseUnits(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.GlucoseUnits()
 }
 
+func lint(pump *medtronic.Pump, _ Arguments) interface{} {
+	b := pump.Backup()
+	if pump.Error() != nil {
+		return nil
+	}
+	issues := medtronic.LintSettings(b, pump.Family())
+	for _, i := range issues {
+		log.Print(i)
+	}
+	return issues
+}
+
 func model(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.Model()
 }
@@ -186,6 +287,24 @@ func reservoir(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.Reservoir()
 }
 
+func restore(pump *medtronic.Pump, args Arguments) interface{} {
+	file, err := args.String("file")
+	if err != nil {
+		restoreUsage(err)
+	}
+	var b medtronic.Backup
+	err = readJSON(file, &b)
+	if err != nil {
+		restoreUsage(err)
+	}
+	log.Printf("restoring pump settings from %s", file)
+	return pump.Restore(b)
+}
+
+func restoreUsage(err error) {
+	cmdError("restore", "backup.json", err)
+}
+
 func resume(pump *medtronic.Pump, _ Arguments) interface{} {
 	log.Printf("resuming pump")
 	pump.Suspend(false)
@@ -224,6 +343,20 @@ func setMaxBasal(pump *medtronic.Pump, args Arguments) interface{} {
 		cmdError("setmaxbasal", "rate", err)
 	}
 	rate := medtronic.Insulin(math.Round(1000.0 * f))
+	// Check that the existing basal schedules do not exceed the new limit.
+	b := medtronic.Backup{
+		BasalRates:    pump.BasalRates(),
+		BasalPatternA: pump.BasalPatternA(),
+		BasalPatternB: pump.BasalPatternB(),
+	}
+	b.Settings.MaxBasal = rate
+	if pump.Error() != nil {
+		return nil
+	}
+	err = medtronic.LintSettings(b, pump.Family()).Err()
+	if err != nil {
+		cmdError("setmaxbasal", "rate", err)
+	}
 	log.Printf("setting max basal rate to %v units/hour", rate)
 	pump.SetMaxBasal(rate)
 	return nil
@@ -273,6 +406,15 @@ func setTempBasalUsage(err error) {
 	cmdError("settempbasal", "temp rate duration", err)
 }
 
+func readJSON(file string, v interface{}) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
 func settings(pump *medtronic.Pump, _ Arguments) interface{} {
 	return pump.Settings()
 }
@@ -299,3 +441,23 @@ func wakeup(pump *medtronic.Pump, _ Arguments) interface{} {
 	// pump.Wakeup has already been called
 	return nil
 }
+
+func wizard(pump *medtronic.Pump, _ Arguments) interface{} {
+	return pump.BolusWizardConfig()
+}
+
+// Wizard usage is summarized over this interval and these parts of the day.
+var (
+	wizardHistory = 30 * 24 * time.Hour
+	wizardPeriods = []medtronic.TimeOfDay{
+		medtronic.TimeOfDay(6 * time.Hour),
+		medtronic.TimeOfDay(11 * time.Hour),
+		medtronic.TimeOfDay(17 * time.Hour),
+		medtronic.TimeOfDay(22 * time.Hour),
+	}
+)
+
+func wizardStats(pump *medtronic.Pump, _ Arguments) interface{} {
+	uses := pump.WizardUses(time.Now().Add(-wizardHistory))
+	return medtronic.SummarizeWizardUses(uses, wizardPeriods)
+}
@@ -83,6 +83,18 @@ func main() {
 	if result != nil {
 		printFn(result)
 	}
+	switch r := result.(type) {
+	case []medtronic.SettingsChange:
+		// Settings drift detected by the diff command.
+		if len(r) != 0 {
+			os.Exit(3)
+		}
+	case medtronic.LintIssues:
+		// Invalid settings detected by the lint command.
+		if len(r.Errors()) != 0 {
+			os.Exit(3)
+		}
+	}
 }
 
 func exitOnError(pump *medtronic.Pump) {
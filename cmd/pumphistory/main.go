@@ -23,11 +23,26 @@ var (
 	all       = flag.Bool("a", false, "get entire pump history")
 	numHours  = flag.Int("n", 6, "number of `hours` of history to get")
 	nsFlag    = flag.Bool("ns", false, "format as Nightscout treatments")
-	fromFlag  = flag.String("f", "", "get history since the specified record `ID` (the base64-encoding of the record data)")
+	oref0Flag = flag.Bool("openaps", false, "format as oref0 pumphistory.json")
+	fromFlag  = flag.String("f", "", "get history since the specified record `ID` (a record ID, as logged with -a, -f, or -store, or the base64-encoding of the record data)")
 	sinceFlag = flag.String("s", "", "get history since the specified `time` in RFC3339 format")
+	storeFlag = flag.String("store", "", "sync new records into the history store in `file` and print only those")
+	cacheFlag = flag.String("cache", "", "cache history pages in `file` to avoid downloading them again")
+	utcFlag   = flag.Bool("u", false, "normalize timestamps to UTC, correcting for pump clock changes and drift")
+	dailyFlag = flag.Bool("daily", false, "print daily totals reconciled with the pump's daily total records")
+	auditFlag = flag.Bool("settings", false, "print an audit log of settings changes")
+	csvFlag   = flag.Bool("csv", false, "format as CSV")
+	zoneFlag  = flag.String("tz", "", "convert CSV timestamps to the time `zone` with the given name")
+	bgFlag    = flag.String("bg", "mg/dL", "glucose `units` for CSV output (mg/dL or mmol/L)")
+	carbsFlag = flag.String("carbs", "g", "carb `units` for CSV output (g or exch)")
+	tidepool  = flag.String("tidepool", "", "write history and pump settings to `file` in Tidepool format")
+	fhir      = flag.String("fhir", "", "write insulin delivery and glucose values to `file` as a FHIR bundle")
+	patient   = flag.String("patient", "", "FHIR `reference` to the patient using the pump, such as Patient/123")
 
-	cutoff   time.Time
-	recordID []byte
+	cutoff     time.Time
+	recordID   []byte
+	stableID   medtronic.RecordID
+	csvOptions medtronic.CSVOptions
 )
 
 func main() {
@@ -35,16 +50,69 @@ func main() {
 	pump := medtronic.Open()
 	defer pump.Close()
 	pump.Wakeup()
+	var cache *medtronic.PageCache
+	if *cacheFlag != "" {
+		cache = loadCache(pump)
+	}
+	var pumpClock, hostTime time.Time
+	if *utcFlag {
+		pumpClock = pump.Clock()
+		hostTime = time.Now()
+	}
 	var results medtronic.History
+	var ids []medtronic.RecordID
 	found := true
-	if *fromFlag != "" {
+	if *storeFlag != "" {
+		results = syncStore(pump)
+	} else if stableID != "" {
+		results, ids, found = pump.HistoryFromID(stableID)
+	} else if *fromFlag != "" {
 		results, found = pump.HistoryFrom(recordID)
 	} else {
 		results = pump.History(cutoff)
+		if *all {
+			// Record IDs are reliable only if the entire history was scanned,
+			// so none is logged with -n or -s.
+			ids = medtronic.RecordIDs(results)
+		}
+	}
+	if *utcFlag {
+		results = medtronic.NormalizeHistory(results, pumpClock, hostTime)
+	}
+	if len(ids) != 0 {
+		log.Printf("most recent record is %s", ids[0])
+	}
+	if cache != nil {
+		err := cache.Save(*cacheFlag)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	if *tidepool != "" {
+		writeTidepool(pump, results)
+	}
+	if *fhir != "" {
+		writeFHIR(pump, results)
 	}
-	if *nsFlag {
+	if *dailyFlag {
+		fmt.Println(nightscout.JSON(medtronic.ReconcileDailyTotals(results)))
+	} else if *auditFlag {
+		current := pump.Backup()
+		audit, err := medtronic.ReplaySettings(results, current).AuditLog()
+		if err != nil {
+			log.Print(err)
+		}
+		fmt.Println(nightscout.JSON(audit))
+	} else if *nsFlag {
 		medtronic.ReverseHistory(results)
 		fmt.Println(nightscout.JSON(medtronic.Treatments(results)))
+	} else if *oref0Flag {
+		fmt.Println(nightscout.JSON(medtronic.OpenAPSHistory(results)))
+	} else if *csvFlag {
+		err := medtronic.WriteHistoryCSV(os.Stdout, results, csvOptions)
+		if err != nil {
+			log.Print(err)
+		}
 	} else {
 		fmt.Println(nightscout.JSON(results))
 	}
@@ -62,13 +130,88 @@ func main() {
 	os.Exit(success)
 }
 
+func writeTidepool(pump *medtronic.Pump, results medtronic.History) {
+	settings := pump.Backup()
+	if pump.Error() != nil {
+		log.Print(pump.Error())
+		return
+	}
+	id := medtronic.TidepoolDeviceID(settings.Model, settings.PumpID)
+	data := medtronic.TidepoolHistory(results, nil, id)
+	data = append(data, medtronic.TidepoolSettings(settings, id))
+	err := data.Save(*tidepool)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Printf("wrote %d Tidepool records to %s", len(data), *tidepool)
+}
+
+func writeFHIR(pump *medtronic.Pump, results medtronic.History) {
+	model := pump.Model()
+	firmware := pump.FirmwareVersion()
+	pumpID := pump.PumpID()
+	if pump.Error() != nil {
+		log.Print(pump.Error())
+		return
+	}
+	device := medtronic.NewFHIRDevice(model, firmware, pumpID)
+	bundle := medtronic.FHIRExport(results, nil, device, *patient)
+	err := bundle.Save(*fhir)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	log.Printf("wrote %d FHIR resources to %s", len(bundle.Entry), *fhir)
+}
+
+func loadCache(pump *medtronic.Pump) *medtronic.PageCache {
+	cache, err := medtronic.LoadPageCache(*cacheFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pump.SetPageCache(cache)
+	return cache
+}
+
+// syncStore adds new records to the history store
+// and returns them in reverse chronological order.
+func syncStore(pump *medtronic.Pump) medtronic.History {
+	store, err := medtronic.OpenHistoryStore(*storeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if last := store.LastID(); last != "" {
+		log.Printf("syncing pump history since record %s", last)
+	}
+	results := pump.SyncHistory(store)
+	log.Printf("added %d records to %s", len(results), *storeFlag)
+	if last := store.LastID(); last != "" {
+		log.Printf("most recent record is %s", last)
+	}
+	medtronic.ReverseHistory(results)
+	return results
+}
+
 func parseFlags() {
 	flag.Parse()
 	var err error
+	if *csvFlag {
+		csvOptions, err = medtronic.ParseCSVOptions(*zoneFlag, *bgFlag, *carbsFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *storeFlag != "" {
+		return
+	}
 	if *all {
 		log.Printf("retrieving entire pump history")
 	} else if *fromFlag != "" {
-		recordID, err = base64.StdEncoding.DecodeString(*fromFlag)
+		stableID, err = medtronic.ParseRecordID(*fromFlag)
+		if err != nil {
+			recordID, err = base64.StdEncoding.DecodeString(*fromFlag)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
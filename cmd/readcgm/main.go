@@ -16,12 +16,23 @@ import (
 var (
 	verbose = flag.Bool("v", false, "print record details")
 	nsFlag  = flag.Bool("t", false, "format as Nightscout treatments")
+	csvFlag = flag.Bool("csv", false, "format as CSV")
+	zone    = flag.String("tz", "", "convert CSV timestamps to the time `zone` with the given name")
+	bgUnits = flag.String("bg", "mg/dL", "glucose `units` for CSV output (mg/dL or mmol/L)")
 
 	timeBlank = strings.Repeat(" ", len(medtronic.UserTimeLayout))
 )
 
 func main() {
 	flag.Parse()
+	var csvOptions medtronic.CSVOptions
+	if *csvFlag {
+		var err error
+		csvOptions, err = medtronic.ParseCSVOptions(*zone, *bgUnits, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	var history medtronic.CGMHistory
 	for _, file := range flag.Args() {
 		f, err := os.Open(file)
@@ -43,6 +54,11 @@ func main() {
 		fmt.Println(nightscout.JSON(history))
 	} else if *nsFlag {
 		fmt.Println(nightscout.JSON(medtronic.NightscoutEntries(history)))
+	} else if *csvFlag {
+		err := medtronic.WriteCGMHistoryCSV(os.Stdout, history, csvOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
 	} else {
 		for _, r := range history {
 			printRecord(r)
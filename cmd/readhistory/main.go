@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ecc1/medtronic"
 	"github.com/ecc1/nightscout"
@@ -16,6 +17,13 @@ var (
 	verbose = flag.Bool("v", false, "print record details")
 	model   = flag.Int("m", 523, "pump model")
 	nsFlag  = flag.Bool("t", false, "format as Nightscout treatments")
+	check   = flag.Bool("c", false, "check pages for anomalies (files in reverse chronological order)")
+	csvFlag = flag.Bool("csv", false, "format as CSV (files in reverse chronological order)")
+	zone    = flag.String("tz", "", "convert CSV timestamps to the time `zone` with the given name")
+	bgUnits = flag.String("bg", "mg/dL", "glucose `units` for CSV output (mg/dL or mmol/L)")
+	carbs   = flag.String("carbs", "g", "carb `units` for CSV output (g or exch)")
+
+	csvOptions medtronic.CSVOptions
 
 	timeBlank = strings.Repeat(" ", len(medtronic.UserTimeLayout))
 )
@@ -23,6 +31,17 @@ var (
 func main() {
 	flag.Parse()
 	family := medtronic.Family(*model % 100)
+	if *csvFlag {
+		var err error
+		csvOptions, err = medtronic.ParseCSVOptions(*zone, *bgUnits, *carbs)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var pages [][]byte
+	// CSV output is written once, after the records from all files are collected.
+	csvOutput := *csvFlag && !*verbose && !*nsFlag
+	var csvRecords medtronic.History
 	for _, file := range flag.Args() {
 		f, err := os.Open(file)
 		if err != nil {
@@ -33,8 +52,29 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		if *check {
+			pages = append(pages, data)
+			continue
+		}
+		if csvOutput {
+			records, err := medtronic.DecodeHistory(data, family)
+			if err != nil {
+				log.Printf("%s: %v", file, err)
+			}
+			csvRecords = append(csvRecords, records...)
+			continue
+		}
 		readHistory(data, family)
 	}
+	if *check {
+		reports := medtronic.CheckHistoryPages(pages, family, time.Now())
+		fmt.Println(nightscout.JSON(reports))
+	} else if csvOutput {
+		err := medtronic.WriteHistoryCSV(os.Stdout, csvRecords, csvOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func readBytes(r io.Reader) ([]byte, error) {
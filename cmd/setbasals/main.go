@@ -30,22 +30,41 @@ func main() {
 	if len(sched) == 0 {
 		log.Fatal("cannot set an empty schedule")
 	}
-	if sched[0].Start != 0 {
-		log.Fatal("schedule must begin at 00:00")
-	}
 	if *simulate {
+		// The pump family does not affect basal schedule checks.
+		lint(sched, 0, 0)
 		showJSON(sched)
 		return
 	}
 	pump = medtronic.Open()
 	defer pump.Close()
 	pump.Wakeup()
+	maxBasal := pump.Settings().MaxBasal
+	family := pump.Family()
+	if pump.Error() != nil {
+		log.Fatal(pump.Error())
+	}
+	lint(sched, maxBasal, family)
 	pump.SetBasalRates(sched)
 	if pump.Error() != nil {
 		log.Fatal(pump.Error())
 	}
 }
 
+// lint checks the schedule before it is sent to the pump.
+// A zero maxBasal skips the check against the pump's max basal rate.
+func lint(sched medtronic.BasalRateSchedule, maxBasal medtronic.Insulin, family medtronic.Family) {
+	b := medtronic.Backup{BasalRates: sched}
+	b.Settings.MaxBasal = maxBasal
+	issues := medtronic.LintSettings(b, family)
+	for _, i := range issues {
+		log.Print(i)
+	}
+	if err := issues.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func hourlySchedule(rates []string) medtronic.BasalRateSchedule {
 	var sched medtronic.BasalRateSchedule
 	for i, arg := range rates {
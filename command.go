@@ -126,9 +126,7 @@ var (
 	ackPacket   []byte
 )
 
-func precomputePackets() {
-	addr := PumpAddress()
-
+func precomputePackets(addr []byte) {
 	shortPacket[0] = packet.Pump
 	copy(shortPacket[1:4], addr)
 
@@ -295,7 +293,18 @@ var pageData = map[Command]pageStructure{
 }
 
 // Download requests the given history page from the pump.
+// History pages are taken from the pump's page cache, if it has one,
+// when they are known not to have changed.
 func (pump *Pump) Download(cmd Command, page int) []byte {
+	if cmd == historyPage && pump.pageCache != nil {
+		return pump.pageCache.get(page, func(page int) []byte {
+			return pump.download(cmd, page)
+		})
+	}
+	return pump.download(cmd, page)
+}
+
+func (pump *Pump) download(cmd Command, page int) []byte {
 	maxTries := pump.Retries()
 	defer pump.SetRetries(maxTries)
 	pump.SetRetries(1)
@@ -0,0 +1,237 @@
+package medtronic
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Recent insulin usage is measured over this interval
+	// to predict when the reservoir will run dry.
+	usageWindow = 3 * 24 * time.Hour
+
+	// Amount of history retrieved by Pump.Consumables.
+	consumablesHistory = 30 * 24 * time.Hour
+)
+
+// ConsumableChange records when a consumable was last changed.
+type ConsumableChange struct {
+	Time time.Time
+	Age  Duration
+}
+
+// ReservoirUsage records the insulin used from a reservoir,
+// from the rewind that preceded filling it until it was replaced
+// (or until now, for the current reservoir).
+// Complete is false if the basal delivery over that interval
+// could not be entirely reconstructed from the history.
+type ReservoirUsage struct {
+	Start     time.Time
+	End       time.Time
+	Primed    Insulin
+	Delivered Insulin
+	Complete  bool
+}
+
+// Used returns the total insulin used from the reservoir.
+func (u ReservoirUsage) Used() Insulin {
+	return u.Primed + u.Delivered
+}
+
+// Consumables summarizes the age and usage of the pump's consumables.
+type Consumables struct {
+	Time      time.Time
+	Reservoir *ConsumableChange `json:",omitempty"`
+	Site      *ConsumableChange `json:",omitempty"` // from the last fixed (cannula) prime
+	Battery   *ConsumableChange `json:",omitempty"`
+	Sensor    *ConsumableChange `json:",omitempty"`
+
+	// Most recent warnings since the corresponding change.
+	LowReservoir *time.Time `json:",omitempty"`
+	LowBattery   *time.Time `json:",omitempty"`
+
+	Reservoirs []ReservoirUsage
+
+	// Insulin delivered per day, averaged over recent history.
+	DailyUsage Insulin
+	// Insulin remaining in the reservoir and when it is predicted to run dry,
+	// if known.
+	Remaining Insulin    `json:",omitempty"`
+	Empty     *time.Time `json:",omitempty"`
+}
+
+func changedAt(t, now time.Time) *ConsumableChange {
+	if t.IsZero() {
+		return nil
+	}
+	return &ConsumableChange{Time: t, Age: Duration(now.Sub(t).Truncate(time.Minute))}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// TrackConsumables determines the age and usage of the pump's consumables
+// as of the given time, from history records and CGM records
+// (both in reverse chronological order).
+// The history is assumed to be complete up to that time.
+// The CGM records may be nil if the pump has no sensor.
+func TrackConsumables(records History, cgm CGMHistory, now time.Time) Consumables {
+	var rewind, site, battery, sensor, lowReservoir, lowBattery time.Time
+	for _, r := range records {
+		if r.Time.IsZero() {
+			continue
+		}
+		switch r.Type() {
+		case Rewind:
+			rewind = latest(rewind, r.Time)
+		case Prime:
+			if r.Info.(PrimeRecord).Fixed != 0 {
+				site = latest(site, r.Time)
+			}
+		case BatteryChange:
+			battery = latest(battery, r.Time)
+		case LowReservoir:
+			lowReservoir = latest(lowReservoir, r.Time)
+		case LowBattery:
+			lowBattery = latest(lowBattery, r.Time)
+		}
+	}
+	for _, r := range cgm {
+		if r.Type == CGMSync && r.Value == "new" {
+			sensor = latest(sensor, r.Time)
+		}
+	}
+	if lowReservoir.Before(rewind) {
+		lowReservoir = time.Time{}
+	}
+	if lowBattery.Before(battery) {
+		lowBattery = time.Time{}
+	}
+	segments := basalTimelineUntil(records, now)
+	c := Consumables{
+		Time:         now,
+		Reservoir:    changedAt(rewind, now),
+		Site:         changedAt(site, now),
+		Battery:      changedAt(battery, now),
+		Sensor:       changedAt(sensor, now),
+		LowReservoir: timePtr(lowReservoir),
+		LowBattery:   timePtr(lowBattery),
+		Reservoirs:   reservoirUsage(records, segments, now),
+	}
+	delivered, covered := deliveredBetween(records, segments, now.Add(-usageWindow), now)
+	if covered > 0 {
+		c.DailyUsage = Insulin(math.Round(float64(delivered) * float64(24*time.Hour) / float64(covered)))
+	}
+	return c
+}
+
+func latest(t, u time.Time) time.Time {
+	if u.After(t) {
+		return u
+	}
+	return t
+}
+
+// reservoirUsage returns the insulin used from each reservoir
+// whose rewind appears in the history, in chronological order.
+func reservoirUsage(records History, segments []BasalSegment, now time.Time) []ReservoirUsage {
+	var usage []ReservoirUsage
+	end := now
+	var primed Insulin
+	for _, r := range records {
+		if r.Time.IsZero() || r.Time.After(end) {
+			continue
+		}
+		switch r.Type() {
+		case Prime:
+			p := r.Info.(PrimeRecord)
+			primed += p.Fixed + p.Manual
+		case Rewind:
+			delivered, covered := deliveredBetween(records, segments, r.Time, end)
+			usage = append(usage, ReservoirUsage{
+				Start:     r.Time,
+				End:       end,
+				Primed:    primed,
+				Delivered: delivered,
+				Complete:  covered == end.Sub(r.Time),
+			})
+			end = r.Time
+			primed = 0
+		}
+	}
+	for i, j := 0, len(usage)-1; i < j; i, j = i+1, j-1 {
+		usage[i], usage[j] = usage[j], usage[i]
+	}
+	return usage
+}
+
+// deliveredBetween returns the insulin delivered by boluses and basal
+// over the interval [start, end), and how much of that interval
+// is covered by the basal timeline.
+func deliveredBetween(records History, segments []BasalSegment, start, end time.Time) (Insulin, time.Duration) {
+	total := 0.0
+	var covered time.Duration
+	for _, seg := range segments {
+		s, e := seg.Start, seg.End
+		if s.Before(start) {
+			s = start
+		}
+		if e.After(end) {
+			e = end
+		}
+		if !e.After(s) {
+			continue
+		}
+		covered += e.Sub(s)
+		total += float64(seg.Rate) * e.Sub(s).Hours()
+	}
+	for _, r := range records {
+		if r.Type() == Bolus && !r.Time.Before(start) && r.Time.Before(end) {
+			total += float64(r.Info.(BolusRecord).Amount)
+		}
+	}
+	return Insulin(math.Round(total)), covered
+}
+
+// SetReservoir records the insulin remaining in the reservoir
+// (from Pump.Reservoir) and predicts when it will run dry
+// at the recent rate of usage.
+func (c *Consumables) SetReservoir(remaining Insulin) {
+	c.Remaining = remaining
+	c.Empty = nil
+	if c.DailyUsage <= 0 {
+		return
+	}
+	d := time.Duration(float64(remaining) / float64(c.DailyUsage) * float64(24*time.Hour))
+	c.Empty = timePtr(c.Time.Add(d).Truncate(time.Minute))
+}
+
+// Consumables retrieves recent pump history (and CGM history,
+// for pumps that support a sensor) along with the reservoir level,
+// and determines the age and usage of the pump's consumables.
+func (pump *Pump) Consumables() Consumables {
+	now := time.Now()
+	since := now.Add(-consumablesHistory)
+	records := pump.History(since)
+	if pump.Error() != nil {
+		return Consumables{}
+	}
+	var cgm CGMHistory
+	if pump.Family() >= 22 {
+		cgm = pump.CGMHistory(since)
+		if pump.Error() != nil {
+			return Consumables{}
+		}
+	}
+	remaining := pump.Reservoir()
+	if pump.Error() != nil {
+		return Consumables{}
+	}
+	c := TrackConsumables(records, cgm, now)
+	c.SetReservoir(remaining)
+	return c
+}
@@ -0,0 +1,70 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackConsumables(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testDose(Bolus, "2020-03-11T08:00:00", BolusRecord{Amount: 4000}),
+		testRecord(LowBattery, "2020-03-11T06:00:00"),
+		profileStart("2020-03-11T00:00:00", "00:00", 1000),
+		testDose(Prime, "2020-03-10T12:10:00", PrimeRecord{Fixed: 300}),
+		testDose(Prime, "2020-03-10T12:00:00", PrimeRecord{Manual: 10000}),
+		testRecord(Rewind, "2020-03-10T12:00:00"),
+		testDose(LowReservoir, "2020-03-10T10:00:00", Insulin(20000)),
+		testDose(Bolus, "2020-03-10T09:00:00", BolusRecord{Amount: 2000}),
+		testDose(Prime, "2020-03-10T06:00:00", PrimeRecord{Manual: 8000}),
+		testRecord(Rewind, "2020-03-10T06:00:00"),
+		profileStart("2020-03-10T00:00:00", "00:00", 500),
+		testRecord(BatteryChange, "2020-03-09T12:00:00"),
+		testDose(Prime, "2020-03-08T12:00:00", PrimeRecord{Fixed: 500, Manual: 8000}),
+	}
+	cgm := CGMHistory{
+		{Type: CGMSync, Time: parseTime("2020-03-09T18:00:00"), Value: "new"},
+		{Type: CGMSync, Time: parseTime("2020-03-08T18:00:00"), Value: "old"},
+	}
+	now := parseTime("2020-03-11T12:00:00")
+	c := TrackConsumables(records, cgm, now)
+	change := func(ts string, age time.Duration) *ConsumableChange {
+		return &ConsumableChange{Time: parseTime(ts), Age: Duration(age)}
+	}
+	lowBattery := parseTime("2020-03-11T06:00:00")
+	want := Consumables{
+		Time:       now,
+		Reservoir:  change("2020-03-10T12:00:00", 24*time.Hour),
+		Site:       change("2020-03-10T12:10:00", 23*time.Hour+50*time.Minute),
+		Battery:    change("2020-03-09T12:00:00", 48*time.Hour),
+		Sensor:     change("2020-03-09T18:00:00", 42*time.Hour),
+		LowBattery: &lowBattery,
+		Reservoirs: []ReservoirUsage{
+			{
+				Start:     parseTime("2020-03-10T06:00:00"),
+				End:       parseTime("2020-03-10T12:00:00"),
+				Primed:    8000,
+				Delivered: 2000 + 6*500,
+				Complete:  true,
+			},
+			{
+				Start:     parseTime("2020-03-10T12:00:00"),
+				End:       now,
+				Primed:    10300,
+				Delivered: 4000 + 12*500 + 12*1000,
+				Complete:  true,
+			},
+		},
+		// 30 units over the 36 hours since the first profile start.
+		DailyUsage: 20000,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("TrackConsumables() = %+v, want %+v", c, want)
+	}
+	c.SetReservoir(10000)
+	empty := parseTime("2020-03-12T00:00:00")
+	if c.Remaining != 10000 || c.Empty == nil || !c.Empty.Equal(empty) {
+		t.Errorf("SetReservoir(10000) predicted %v, want %v", c.Empty, empty)
+	}
+}
@@ -0,0 +1,149 @@
+package medtronic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Tolerances for reconciling computed totals with the pump's own.
+	// Boluses are recorded exactly, but basal insulin is delivered
+	// in discrete strokes, so the pump's total is only approximate.
+	bolusTolerance      = 50  // milliUnits
+	basalTolerance      = 200 // milliUnits
+	basalToleranceRatio = 0.05
+)
+
+// DailySummary holds the insulin totals for a day,
+// computed from the boluses and reconstructed basal timeline in the history.
+type DailySummary struct {
+	Day   time.Time // midnight at the start of the day
+	Bolus Insulin
+	Basal Insulin
+	// Whether the basal timeline covers the entire day.
+	Complete bool
+}
+
+// Total returns the total insulin delivered.
+func (s DailySummary) Total() Insulin {
+	return s.Bolus + s.Basal
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// DailySummaries computes the totals for each day in the given history records
+// (in reverse chronological order). The results are in chronological order.
+func DailySummaries(records History) []DailySummary {
+	days := make(map[time.Time]*DailySummary)
+	var order []time.Time
+	summary := func(t time.Time) *DailySummary {
+		day := startOfDay(t)
+		s := days[day]
+		if s == nil {
+			s = &DailySummary{Day: day}
+			days[day] = s
+			order = append(order, day)
+		}
+		return s
+	}
+	covered := make(map[time.Time]time.Duration)
+	basal := make(map[time.Time]float64)
+	for _, seg := range BasalTimeline(records) {
+		for start := seg.Start; start.Before(seg.End); {
+			s := summary(start)
+			end := s.Day.AddDate(0, 0, 1)
+			if seg.End.Before(end) {
+				end = seg.End
+			}
+			d := end.Sub(start)
+			covered[s.Day] += d
+			basal[s.Day] += float64(seg.Rate) * d.Hours()
+			start = end
+		}
+	}
+	for _, r := range records {
+		if r.Type() != Bolus || r.Time.IsZero() {
+			continue
+		}
+		s := summary(r.Time)
+		s.Bolus += r.Info.(BolusRecord).Amount
+	}
+	results := make([]DailySummary, len(order))
+	for i, day := range order {
+		s := days[day]
+		s.Basal = Insulin(math.Round(basal[day]))
+		s.Complete = covered[day] == day.AddDate(0, 0, 1).Sub(day)
+		results[i] = *s
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Day.Before(results[j].Day)
+	})
+	return results
+}
+
+// DailyReconciliation compares the totals recorded by the pump for a day
+// with the totals computed from the history.
+type DailyReconciliation struct {
+	Day      time.Time
+	Pump     DailyTotalRecord
+	Computed DailySummary
+	Problems []string `json:",omitempty"`
+}
+
+// ReconcileDailyTotals compares each DailyTotal515/522/523 record
+// in the given history records (in reverse chronological order)
+// with the totals computed from boluses and the reconstructed basal timeline.
+// Days where they disagree often indicate missing or corrupted history.
+// Days that the history does not cover entirely are reported as such
+// without comparing their totals.
+// The results are in chronological order.
+func ReconcileDailyTotals(records History) []DailyReconciliation {
+	summaries := make(map[time.Time]DailySummary)
+	for _, s := range DailySummaries(records) {
+		summaries[s.Day] = s
+	}
+	var results []DailyReconciliation
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		switch r.Type() {
+		case DailyTotal515, DailyTotal522, DailyTotal523:
+		default:
+			continue
+		}
+		day := startOfDay(r.Time)
+		s, found := summaries[day]
+		if !found {
+			s = DailySummary{Day: day}
+		}
+		results = append(results, reconcileDay(r.Info.(DailyTotalRecord), s))
+	}
+	return results
+}
+
+func reconcileDay(pump DailyTotalRecord, s DailySummary) DailyReconciliation {
+	rec := DailyReconciliation{Day: s.Day, Pump: pump, Computed: s}
+	if !s.Complete {
+		rec.Problems = append(rec.Problems, "history does not cover the entire day")
+		return rec
+	}
+	if diff := s.Bolus - pump.Bolus; diff < -bolusTolerance || bolusTolerance < diff {
+		rec.Problems = append(rec.Problems, mismatch("bolus", pump.Bolus, s.Bolus))
+	}
+	tolerance := Insulin(basalToleranceRatio * float64(pump.Basal))
+	if tolerance < basalTolerance {
+		tolerance = basalTolerance
+	}
+	if diff := s.Basal - pump.Basal; diff < -tolerance || tolerance < diff {
+		rec.Problems = append(rec.Problems, mismatch("basal", pump.Basal, s.Basal))
+	}
+	return rec
+}
+
+func mismatch(kind string, pump, computed Insulin) string {
+	return fmt.Sprintf("%s total is %s but history shows %s", kind, formatInsulin(pump), formatInsulin(computed))
+}
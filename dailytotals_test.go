@@ -0,0 +1,79 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReconcileDailyTotals(t *testing.T) {
+	tc := testCase{"model", 523, 1}
+	records, err := decodeFromData(testFileName(tc)+".json", testPumpFamily(tc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	incomplete := []string{"history does not cover the entire day"}
+	cases := []struct {
+		modify func(History) History
+		want   map[string][]string
+	}{
+		{
+			func(h History) History { return h },
+			map[string][]string{
+				"2016-03-01": incomplete,
+				"2016-03-02": incomplete,
+			},
+		},
+		{
+			// Change the scheduled rate at 08:30 on 2016-03-04.
+			func(h History) History {
+				for i, r := range h {
+					if r.Type() == BasalProfileStart && r.Time.Equal(parseTime("2016-03-04T08:30")) {
+						info := r.Info.(BasalProfileStartRecord)
+						info.BasalRate.Rate += 100
+						h[i].Info = info
+					}
+				}
+				return h
+			},
+			map[string][]string{
+				"2016-03-01": incomplete,
+				"2016-03-02": incomplete,
+				"2016-03-04": {"basal total is 17.55 but history shows 18.90"},
+			},
+		},
+		{
+			// Add a bolus that the pump did not count.
+			func(h History) History {
+				b := testDose(Bolus, "2016-03-05T12:00", BolusRecord{Amount: 1000})
+				for i, r := range h {
+					if r.Time.Before(b.Time) {
+						return append(h[:i], append(History{b}, h[i:]...)...)
+					}
+				}
+				return nil
+			},
+			map[string][]string{
+				"2016-03-01": incomplete,
+				"2016-03-02": incomplete,
+				"2016-03-05": {"bolus total is 0.00 but history shows 1.00"},
+			},
+		},
+	}
+	for _, c := range cases {
+		h := make(History, len(records))
+		copy(h, records)
+		results := ReconcileDailyTotals(c.modify(h))
+		if len(results) != 6 {
+			t.Errorf("ReconcileDailyTotals() returned %d results, want 6", len(results))
+		}
+		problems := make(map[string][]string)
+		for _, r := range results {
+			if len(r.Problems) != 0 {
+				problems[r.Day.Format("2006-01-02")] = r.Problems
+			}
+		}
+		if !reflect.DeepEqual(problems, c.want) {
+			t.Errorf("ReconcileDailyTotals() problems = %v, want %v", problems, c.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package medtronic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SettingsChange represents a difference between two sets of pump settings.
+// Start is nil for settings that are not part of a schedule.
+type SettingsChange struct {
+	Setting string
+	Start   *TimeOfDay `json:",omitempty"`
+	Old     string
+	New     string
+}
+
+func (c SettingsChange) String() string {
+	s := fmt.Sprintf("%s %s → %s", c.Setting, c.Old, c.New)
+	if c.Start != nil {
+		s = c.Start.String() + " " + s
+	}
+	return s
+}
+
+// A segment is the value of a schedule starting at a given time of day.
+type segment struct {
+	start TimeOfDay
+	value string
+}
+
+// valueAt returns the value of a schedule at the given time of day,
+// or "none" if the schedule is empty.
+func valueAt(sched []segment, t TimeOfDay) string {
+	v := "none"
+	for _, s := range sched {
+		if s.start > t {
+			break
+		}
+		v = s.value
+	}
+	return v
+}
+
+// scheduleStarts returns the sorted times of day
+// at which either of two schedules begins a segment.
+func scheduleStarts(a, b []segment) []TimeOfDay {
+	var starts []TimeOfDay
+	seen := make(map[TimeOfDay]bool)
+	for _, s := range append(append([]segment{}, a...), b...) {
+		if !seen[s.start] {
+			seen[s.start] = true
+			starts = append(starts, s.start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts
+}
+
+// diffSchedule compares two schedules at every time of day where either one
+// changes value, so that schedules with different segment boundaries but the
+// same effective values are considered equal.
+func diffSchedule(name string, old, current []segment) []SettingsChange {
+	var changes []SettingsChange
+	for _, t := range scheduleStarts(old, current) {
+		v, w := valueAt(old, t), valueAt(current, t)
+		if v == w {
+			continue
+		}
+		start := t
+		changes = append(changes, SettingsChange{Setting: name, Start: &start, Old: v, New: w})
+	}
+	return changes
+}
+
+func diffValue(name string, old, current string) []SettingsChange {
+	if old == current {
+		return nil
+	}
+	return []SettingsChange{{Setting: name, Old: old, New: current}}
+}
+
+// formatInsulin returns a representation of an insulin quantity
+// with at least two decimal places, omitting a trailing zero.
+func formatInsulin(r Insulin) string {
+	s := r.String()
+	if strings.HasSuffix(s, "0") {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func basalSegments(s BasalRateSchedule) []segment {
+	var v []segment
+	for _, r := range s {
+		v = append(v, segment{r.Start, formatInsulin(r.Rate)})
+	}
+	return v
+}
+
+func carbRatioSegments(s CarbRatioSchedule) []segment {
+	var v []segment
+	for _, r := range s {
+		v = append(v, segment{r.Start, formatRatio(r.Ratio, r.Units)})
+	}
+	return v
+}
+
+func sensitivitySegments(s InsulinSensitivitySchedule) []segment {
+	var v []segment
+	for _, r := range s {
+		v = append(v, segment{r.Start, formatGlucose(r.Sensitivity, r.Units)})
+	}
+	return v
+}
+
+func targetSegments(s GlucoseTargetSchedule) []segment {
+	var v []segment
+	for _, r := range s {
+		target := formatGlucose(r.Low, r.Units)
+		if r.High != r.Low {
+			target = fmt.Sprintf("%s-%s", formatGlucose(r.Low, r.Units), formatGlucose(r.High, r.Units))
+		}
+		v = append(v, segment{r.Start, target})
+	}
+	return v
+}
+
+func basalPatternName(n int) string {
+	if 0 <= n && n < len(basalPatternNames) {
+		return basalPatternNames[n]
+	}
+	return fmt.Sprintf("basal pattern %d", n)
+}
+
+// basalScheduleName returns the name used in settings changes
+// for the given basal schedule.
+func basalScheduleName(n int) string {
+	return strings.TrimPrefix(basalPatternName(n), "standard ")
+}
+
+// DiffSettings returns the differences between a reference set of settings
+// and another set (typically read from a pump).
+// Schedules that are empty in the reference are not compared,
+// and if the reference has no pump settings or units
+// (as when it was converted from a Nightscout profile),
+// those are not compared either.
+func DiffSettings(old, current Backup) []SettingsChange {
+	var changes []SettingsChange
+	add := func(c []SettingsChange) {
+		changes = append(changes, c...)
+	}
+	if old.HasPumpSettings {
+		o, n := old.Settings, current.Settings
+		add(diffValue("max basal", formatInsulin(o.MaxBasal), formatInsulin(n.MaxBasal)))
+		add(diffValue("max bolus", formatInsulin(o.MaxBolus), formatInsulin(n.MaxBolus)))
+		add(diffValue("auto-off", o.AutoOff.String(), n.AutoOff.String()))
+		add(diffValue("insulin concentration", fmt.Sprintf("U%d", o.InsulinConcentration), fmt.Sprintf("U%d", n.InsulinConcentration)))
+		add(diffValue("RF enabled", fmt.Sprint(o.RFEnabled), fmt.Sprint(n.RFEnabled)))
+		add(diffValue("temp basal type", o.TempBasalType.String(), n.TempBasalType.String()))
+		add(diffValue("selected basal pattern", basalPatternName(o.SelectedPattern), basalPatternName(n.SelectedPattern)))
+	}
+	if old.Settings.InsulinAction != 0 {
+		add(diffValue("insulin action", old.Settings.InsulinAction.String(), current.Settings.InsulinAction.String()))
+	}
+	if old.CarbUnits != 0 {
+		add(diffValue("carb units", old.CarbUnits.String(), current.CarbUnits.String()))
+	}
+	if old.GlucoseUnits != 0 {
+		add(diffValue("glucose units", old.GlucoseUnits.String(), current.GlucoseUnits.String()))
+	}
+	for i, s := range old.basalSchedules() {
+		if len(s) != 0 {
+			add(diffSchedule(basalScheduleName(i), basalSegments(s), basalSegments(current.basalSchedules()[i])))
+		}
+	}
+	if len(old.CarbRatios) != 0 {
+		add(diffSchedule("carb ratio", carbRatioSegments(old.CarbRatios), carbRatioSegments(current.CarbRatios)))
+	}
+	if len(old.Sensitivities) != 0 {
+		add(diffSchedule("sensitivity", sensitivitySegments(old.Sensitivities), sensitivitySegments(current.Sensitivities)))
+	}
+	if len(old.Targets) != 0 {
+		add(diffSchedule("target", targetSegments(old.Targets), targetSegments(current.Targets)))
+	}
+	return changes
+}
@@ -0,0 +1,165 @@
+package medtronic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ecc1/nightscout"
+)
+
+func TestDiffSettings(t *testing.T) {
+	cases := []struct {
+		old     Backup
+		current Backup
+		changes []string
+	}{
+		{
+			testBackup(),
+			testBackup(),
+			nil,
+		},
+		{
+			Backup{
+				BasalRates: BasalRateSchedule{
+					{parseTD("00:00"), 850},
+					{parseTD("06:00"), 850},
+				},
+			},
+			Backup{
+				BasalRates: BasalRateSchedule{
+					{parseTD("00:00"), 850},
+				},
+			},
+			nil,
+		},
+		{
+			Backup{
+				BasalRates: BasalRateSchedule{
+					{parseTD("00:00"), 800},
+					{parseTD("06:00"), 850},
+				},
+				Targets: GlucoseTargetSchedule{{parseTD("00:00"), 100, 120, MgPerDeciLiter}},
+			},
+			Backup{
+				BasalRates: BasalRateSchedule{
+					{parseTD("00:00"), 800},
+					{parseTD("06:00"), 900},
+					{parseTD("22:00"), 800},
+				},
+				Targets: GlucoseTargetSchedule{{parseTD("00:00"), 110, 110, MgPerDeciLiter}},
+			},
+			[]string{
+				"06:00 basal 0.85 → 0.90",
+				"22:00 basal 0.85 → 0.80",
+				"00:00 target 100 mg/dL-120 mg/dL → 110 mg/dL",
+			},
+		},
+		{
+			testBackup(),
+			func() Backup {
+				b := testBackup()
+				b.Settings.MaxBasal = 3000
+				b.Settings.InsulinAction = 4 * time.Hour
+				b.BasalPatternA = nil
+				b.CarbRatios[0].Ratio = 80
+				return b
+			}(),
+			[]string{
+				"max basal 2.00 → 3.00",
+				"insulin action 3h0m0s → 4h0m0s",
+				"00:00 basal pattern A 0.50 → none",
+				"00:00 carb ratio 6.5 g/U → 8.0 g/U",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			var changes []string
+			for _, d := range DiffSettings(c.old, c.current) {
+				changes = append(changes, d.String())
+			}
+			if !reflect.DeepEqual(changes, c.changes) {
+				t.Errorf("DiffSettings(%+v, %+v) == %q, want %q", c.old, c.current, changes, c.changes)
+			}
+		})
+	}
+}
+
+func TestProfileSettings(t *testing.T) {
+	data := []byte(`{
+		"defaultProfile": "Default",
+		"store": {
+			"Default": {
+				"dia": 4,
+				"basal": [{"time": "00:00", "value": "0.85"}, {"time": "06:00", "value": 1.1}],
+				"carbratio": [{"time": "00:00", "value": 10}],
+				"sens": [{"time": "00:00", "value": "2.5"}],
+				"target_low": [{"time": "00:00", "value": 5.5}, {"time": "22:00", "value": 6}],
+				"target_high": [{"time": "00:00", "value": 6}],
+				"units": "mmol"
+			}
+		}
+	}`)
+	var p nightscout.Profile
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ProfileSettings(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Backup{
+		Version:      BackupVersion,
+		CarbUnits:    Grams,
+		GlucoseUnits: MMolPerLiter,
+		Settings:     SettingsInfo{InsulinAction: 4 * time.Hour},
+		BasalRates: BasalRateSchedule{
+			{parseTD("00:00"), 850},
+			{parseTD("06:00"), 1100},
+		},
+		CarbRatios:    CarbRatioSchedule{{parseTD("00:00"), 100, Grams}},
+		Sensitivities: InsulinSensitivitySchedule{{parseTD("00:00"), 2500, MMolPerLiter}},
+		Targets: GlucoseTargetSchedule{
+			{parseTD("00:00"), 5500, 6000, MMolPerLiter},
+			{parseTD("22:00"), 6000, 6000, MMolPerLiter},
+		},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("ProfileSettings(%s) == %+v, want %+v", data, b, want)
+	}
+	// Only the settings in the profile should be compared.
+	pump := want
+	pump.HasPumpSettings = true
+	pump.Settings = SettingsInfo{InsulinAction: 4 * time.Hour, MaxBasal: 2000, MaxBolus: 10000, InsulinConcentration: 100, RFEnabled: true, SelectedPattern: 1}
+	if changes := DiffSettings(b, pump); len(changes) != 0 {
+		t.Errorf("DiffSettings(profile, pump) == %v, want no changes", changes)
+	}
+}
+
+func TestProfileTargets(t *testing.T) {
+	cases := []struct {
+		low, high string
+		ok        bool
+	}{
+		{`[{"time": "00:00", "value": 100}]`, `[{"time": "00:00", "value": 120}]`, true},
+		{`[{"time": "00:00", "value": 100}]`, `[{"time": "06:00", "value": 120}]`, false},
+		{`[{"time": "00:00", "value": 100}]`, `[]`, false},
+		{`[{"time": "00:00", "value": "high"}]`, `[{"time": "00:00", "value": 120}]`, false},
+	}
+	for _, c := range cases {
+		var low, high nightscout.Schedule
+		if err := json.Unmarshal([]byte(c.low), &low); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(c.high), &high); err != nil {
+			t.Fatal(err)
+		}
+		_, err := profileTargets(low, high, MgPerDeciLiter)
+		if (err == nil) != c.ok {
+			t.Errorf("profileTargets(%s, %s) error = %v, want ok = %v", c.low, c.high, err, c.ok)
+		}
+	}
+}
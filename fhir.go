@@ -0,0 +1,377 @@
+package medtronic
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Types for the subset of FHIR R4 resources produced by FHIRExport.
+
+// FHIRBundle is a collection of FHIR resources.
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type"`
+	Timestamp    string            `json:"timestamp,omitempty"`
+	Entry        []FHIRBundleEntry `json:"entry"`
+}
+
+// FHIRBundleEntry is a resource in a bundle.
+type FHIRBundleEntry struct {
+	FullURL  string      `json:"fullUrl"`
+	Resource interface{} `json:"resource"`
+}
+
+// FHIRDevice describes a pump.
+type FHIRDevice struct {
+	ResourceType string              `json:"resourceType"`
+	ID           string              `json:"id"`
+	Identifier   []FHIRIdentifier    `json:"identifier,omitempty"`
+	Manufacturer string              `json:"manufacturer"`
+	DeviceName   []FHIRDeviceName    `json:"deviceName,omitempty"`
+	ModelNumber  string              `json:"modelNumber,omitempty"`
+	SerialNumber string              `json:"serialNumber,omitempty"`
+	Type         FHIRCodeableConcept `json:"type"`
+	Version      []FHIRDeviceVersion `json:"version,omitempty"`
+}
+
+// FHIRObservation is a glucose measurement.
+type FHIRObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	ID                string                `json:"id"`
+	Status            string                `json:"status"`
+	Category          []FHIRCodeableConcept `json:"category"`
+	Code              FHIRCodeableConcept   `json:"code"`
+	Subject           FHIRReference         `json:"subject"`
+	EffectiveDateTime string                `json:"effectiveDateTime"`
+	ValueQuantity     FHIRQuantity          `json:"valueQuantity"`
+	Device            FHIRReference         `json:"device"`
+}
+
+// FHIRMedicationAdministration is a bolus or a period of basal delivery.
+type FHIRMedicationAdministration struct {
+	ResourceType              string              `json:"resourceType"`
+	ID                        string              `json:"id"`
+	Status                    string              `json:"status"`
+	MedicationCodeableConcept FHIRCodeableConcept `json:"medicationCodeableConcept"`
+	Subject                   FHIRReference       `json:"subject"`
+	EffectiveDateTime         string              `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod           *FHIRPeriod         `json:"effectivePeriod,omitempty"`
+	Device                    []FHIRReference     `json:"device"`
+	Dosage                    FHIRDosage          `json:"dosage"`
+}
+
+// FHIRIdentifier is an identifier for a resource.
+type FHIRIdentifier struct {
+	Type  *FHIRCodeableConcept `json:"type,omitempty"`
+	Value string               `json:"value"`
+}
+
+// FHIRDeviceName is a name for a device.
+type FHIRDeviceName struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// FHIRDeviceVersion is a version of a device's software.
+type FHIRDeviceVersion struct {
+	Value string `json:"value"`
+}
+
+// FHIRCodeableConcept is a set of codes for a concept.
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+// FHIRCoding is a code from a terminology system.
+type FHIRCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// FHIRReference is a reference to another resource.
+type FHIRReference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+// FHIRQuantity is a measured amount in UCUM units.
+type FHIRQuantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	System string  `json:"system"`
+	Code   string  `json:"code"`
+}
+
+// FHIRPeriod is a time interval.
+type FHIRPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// FHIRDosage describes how a medication was administered.
+type FHIRDosage struct {
+	Text         string              `json:"text"`
+	Route        FHIRCodeableConcept `json:"route"`
+	Dose         FHIRQuantity        `json:"dose"`
+	RateQuantity *FHIRQuantity       `json:"rateQuantity,omitempty"`
+}
+
+const (
+	loincSystem  = "http://loinc.org"
+	snomedSystem = "http://snomed.info/sct"
+	ucumSystem   = "http://unitsofmeasure.org"
+)
+
+var (
+	fhirInsulin = FHIRCodeableConcept{
+		Coding: []FHIRCoding{{System: snomedSystem, Code: "67866001", Display: "Insulin"}},
+		Text:   "Insulin",
+	}
+
+	fhirSubcutaneous = FHIRCodeableConcept{
+		Coding: []FHIRCoding{{System: snomedSystem, Code: "34206005", Display: "Subcutaneous route"}},
+	}
+
+	fhirLaboratory = []FHIRCodeableConcept{{
+		Coding: []FHIRCoding{{
+			System: "http://terminology.hl7.org/CodeSystem/observation-category",
+			Code:   "laboratory",
+		}},
+	}}
+
+	loincSensorGlucose = FHIRCoding{System: loincSystem, Code: "99504-3", Display: "Glucose [Mass/volume] in Interstitial fluid"}
+	loincMeterGlucose  = FHIRCoding{System: loincSystem, Code: "41653-7", Display: "Glucose [Mass/volume] in Capillary blood by Glucometer"}
+	loincMeterGlucoseM = FHIRCoding{System: loincSystem, Code: "14743-9", Display: "Glucose [Moles/volume] in Capillary blood by Glucometer"}
+)
+
+// NewFHIRDevice returns a Device resource for a pump
+// with the given model, firmware version, and ID.
+func NewFHIRDevice(model string, firmwareVersion string, pumpID string) FHIRDevice {
+	d := FHIRDevice{
+		ResourceType: "Device",
+		ID:           "pump-" + pumpID,
+		Identifier:   []FHIRIdentifier{{Value: pumpID}},
+		Manufacturer: "Medtronic",
+		ModelNumber:  model,
+		SerialNumber: pumpID,
+		Type: FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: snomedSystem, Code: "69805005", Display: "Insulin pump"}},
+		},
+	}
+	if model != "" {
+		d.DeviceName = []FHIRDeviceName{{Name: "MiniMed " + model, Type: "model-name"}}
+	}
+	if firmwareVersion != "" {
+		d.Version = []FHIRDeviceVersion{{Value: firmwareVersion}}
+	}
+	return d
+}
+
+type fhirExporter struct {
+	bundle  FHIRBundle
+	subject FHIRReference
+	device  FHIRReference
+	latest  time.Time
+}
+
+// fhirURL returns a name-based UUID URN for a resource,
+// so that the same data always produces the same bundle.
+func fhirURL(resourceType string, id string) string {
+	h := sha1.Sum([]byte(resourceType + "/" + id))
+	h[6] = h[6]&0x0F | 0x50 // version 5
+	h[8] = h[8]&0x3F | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func (e *fhirExporter) add(resourceType string, id string, resource interface{}, t time.Time) {
+	e.bundle.Entry = append(e.bundle.Entry, FHIRBundleEntry{
+		FullURL:  fhirURL(resourceType, id),
+		Resource: resource,
+	})
+	if t.After(e.latest) {
+		e.latest = t
+	}
+}
+
+// fhirRecordID returns an ID for a history record that depends only on
+// its timestamp and contents, unlike a RecordID, so that it is the same
+// whether or not older records are included in the history.
+func fhirRecordID(r HistoryRecord) string {
+	return recordIDTime(r) + "-" + recordHash(r)
+}
+
+func fhirTime(t time.Time) string {
+	return t.Format(JSONTimeLayout)
+}
+
+func fhirInsulinUnits(r Insulin) FHIRQuantity {
+	return FHIRQuantity{Value: float64(r) / 1000, Unit: "U", System: ucumSystem, Code: "[IU]"}
+}
+
+// FHIRExport converts boluses, the basal timeline, and glucose values
+// from pump history and CGM records (in reverse chronological order)
+// into a bundle of FHIR resources, starting with the pump's Device resource.
+// Glucose values sent to the pump by fakemeter are omitted.
+// The patient is a reference to the person using the pump, such as "Patient/123";
+// if it is empty, the subject of each resource is identified only by display text.
+// The bundle's timestamp is that of the most recent record,
+// so the result depends only on its inputs.
+func FHIRExport(records History, cgm CGMHistory, device FHIRDevice, patient string) FHIRBundle {
+	e := fhirExporter{
+		bundle:  FHIRBundle{ResourceType: "Bundle", Type: "collection"},
+		subject: FHIRReference{Reference: patient},
+		device:  FHIRReference{Reference: fhirURL("Device", device.ID)},
+	}
+	if patient == "" {
+		e.subject = FHIRReference{Display: "Pump user"}
+	}
+	e.add("Device", device.ID, device, time.Time{})
+	meterTimes := make(map[time.Time]bool)
+	for _, r := range records {
+		if r.Type() == BGReceived {
+			meterTimes[r.Time] = true
+		}
+	}
+	// Values sent by fakemeter are sensor glucose values already in the CGM records.
+	fromFakeMeter := fakeMeterFilter(records)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Time.IsZero() || fromFakeMeter(r) {
+			continue
+		}
+		switch r.Type() {
+		case Bolus:
+			e.bolus(r, fhirRecordID(r))
+		case BGCapture, BGReceived:
+			// The pump records a BGCapture along with each value received from a meter.
+			if r.Type() == BGCapture && meterTimes[r.Time] {
+				continue
+			}
+			g := r.Info.(GlucoseRecord)
+			e.meterGlucose(r.Time, g.Glucose, g.Units, fhirRecordID(r))
+		}
+	}
+	for _, seg := range BasalTimeline(records) {
+		e.basal(seg)
+	}
+	for i := len(cgm) - 1; i >= 0; i-- {
+		r := cgm[i]
+		if r.Type != CGMGlucose || r.Time.IsZero() || r.Glucose == 0 {
+			continue
+		}
+		e.sensorGlucose(r)
+	}
+	if !e.latest.IsZero() {
+		e.bundle.Timestamp = fhirTime(e.latest)
+	}
+	return e.bundle
+}
+
+func (e *fhirExporter) bolus(r HistoryRecord, id string) {
+	info, ok := r.Info.(BolusRecord)
+	if !ok {
+		return
+	}
+	d, _ := bolusDose(r)
+	m := FHIRMedicationAdministration{
+		ResourceType:              "MedicationAdministration",
+		ID:                        "bolus-" + id,
+		Status:                    "completed",
+		MedicationCodeableConcept: fhirInsulin,
+		Subject:                   e.subject,
+		Device:                    []FHIRReference{e.device},
+		Dosage: FHIRDosage{
+			Text:  "Normal bolus",
+			Route: fhirSubcutaneous,
+			Dose:  fhirInsulinUnits(d.Amount),
+		},
+	}
+	if info.Amount < info.Programmed {
+		m.Status = "stopped"
+	}
+	if info.Duration == 0 {
+		m.EffectiveDateTime = fhirTime(d.Start)
+	} else {
+		m.EffectivePeriod = &FHIRPeriod{Start: fhirTime(d.Start), End: fhirTime(d.Start.Add(d.Duration))}
+		m.Dosage.Text = "Square wave bolus"
+	}
+	e.add(m.ResourceType, m.ID, m, d.Start.Add(d.Duration))
+}
+
+var fhirBasalText = map[BasalReason]string{
+	BasalScheduled: "Scheduled basal",
+	BasalTemp:      "Temporary basal",
+}
+
+// basal adds a MedicationAdministration for each basal segment
+// during which insulin was delivered.
+func (e *fhirExporter) basal(seg BasalSegment) {
+	if seg.Rate == 0 {
+		return
+	}
+	d := seg.End.Sub(seg.Start)
+	rate := fhirInsulinUnits(seg.Rate)
+	rate.Unit = "U/h"
+	rate.Code = "[IU]/h"
+	m := FHIRMedicationAdministration{
+		ResourceType:              "MedicationAdministration",
+		ID:                        "basal-" + seg.Start.Format(recordIDTimeLayout),
+		Status:                    "completed",
+		MedicationCodeableConcept: fhirInsulin,
+		Subject:                   e.subject,
+		EffectivePeriod:           &FHIRPeriod{Start: fhirTime(seg.Start), End: fhirTime(seg.End)},
+		Device:                    []FHIRReference{e.device},
+		Dosage: FHIRDosage{
+			Text:         fhirBasalText[seg.Reason],
+			Route:        fhirSubcutaneous,
+			Dose:         fhirInsulinUnits(Insulin(float64(seg.Rate) * d.Hours())),
+			RateQuantity: &rate,
+		},
+	}
+	e.add(m.ResourceType, m.ID, m, seg.End)
+}
+
+func (e *fhirExporter) observation(id string, t time.Time, code FHIRCoding, value FHIRQuantity) {
+	o := FHIRObservation{
+		ResourceType:      "Observation",
+		ID:                id,
+		Status:            "final",
+		Category:          fhirLaboratory,
+		Code:              FHIRCodeableConcept{Coding: []FHIRCoding{code}},
+		Subject:           e.subject,
+		EffectiveDateTime: fhirTime(t),
+		ValueQuantity:     value,
+		Device:            e.device,
+	}
+	e.add(o.ResourceType, o.ID, o, t)
+}
+
+func (e *fhirExporter) meterGlucose(t time.Time, g Glucose, u GlucoseUnitsType, id string) {
+	if u == MMolPerLiter {
+		// Glucose values in mmol/L are represented in μmol/L.
+		value := FHIRQuantity{Value: float64(g) / 1000, Unit: "mmol/L", System: ucumSystem, Code: "mmol/L"}
+		e.observation("bg-"+id, t, loincMeterGlucoseM, value)
+		return
+	}
+	value := FHIRQuantity{Value: float64(g), Unit: "mg/dL", System: ucumSystem, Code: "mg/dL"}
+	e.observation("bg-"+id, t, loincMeterGlucose, value)
+}
+
+func (e *fhirExporter) sensorGlucose(r CGMRecord) {
+	value := FHIRQuantity{Value: float64(r.Glucose), Unit: "mg/dL", System: ucumSystem, Code: "mg/dL"}
+	e.observation("cgm-"+r.Time.Format(recordIDTimeLayout), r.Time, loincSensorGlucose, value)
+}
+
+// Save writes a FHIR bundle to a file as JSON.
+func (b FHIRBundle) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,105 @@
+package medtronic
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var fhirIDPattern = regexp.MustCompile(`^[A-Za-z0-9\-.]{1,64}$`)
+
+func TestFHIRExport(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testDose(BGCapture, "2020-03-10T13:00:00", GlucoseRecord{Units: MMolPerLiter, Glucose: 6500}),
+		// Sent by fakemeter.
+		testDose(BGCapture, "2020-03-10T12:50:00", GlucoseRecord{Units: MgPerDeciLiter, Glucose: 155}),
+		testDose(BGReceived, "2020-03-10T12:50:00", GlucoseRecord{Units: MgPerDeciLiter, Glucose: 155, MeterID: fakeMeterID}),
+		testDose(BGCapture, "2020-03-10T12:45:00", GlucoseRecord{Units: MgPerDeciLiter, Glucose: 140}),
+		testDose(BGReceived, "2020-03-10T12:45:00", GlucoseRecord{Units: MgPerDeciLiter, Glucose: 140, MeterID: "abcdef"}),
+		testDose(TempBasalDuration, "2020-03-10T12:30:00", Duration(30*time.Minute)),
+		testDose(TempBasalRate, "2020-03-10T12:30:00", TempBasalRecord{Type: Absolute, Value: Insulin(0)}),
+		testDose(Bolus, "2020-03-10T12:20:00", BolusRecord{Programmed: 2000, Amount: 1000, Duration: Duration(time.Hour)}),
+		testDose(Bolus, "2020-03-10T12:10:00", BolusRecord{Programmed: 1500, Amount: 1500}),
+		profileStart("2020-03-10T12:00:00", "12:00", 1000),
+	}
+	cgm := CGMHistory{
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T13:05:00"), Glucose: 150},
+		{Type: CGMSync, Time: parseTime("2020-03-10T13:00:00")},
+	}
+	device := NewFHIRDevice("523", "VER 2.4A1.1", "123456")
+	bundle := FHIRExport(records, cgm, device, "Patient/1")
+	if bundle.Type != "collection" || bundle.Timestamp != "2020-03-10T13:05:00-04:00" {
+		t.Errorf("bundle type = %s, timestamp = %s", bundle.Type, bundle.Timestamp)
+	}
+	deviceURL := bundle.Entry[0].FullURL
+	urls := make(map[string]bool)
+	type summary struct {
+		Type, ID, Status, Code, Effective string
+		Value                             float64
+	}
+	var got []summary
+	for _, e := range bundle.Entry {
+		if urls[e.FullURL] {
+			t.Errorf("duplicate URL %s", e.FullURL)
+		}
+		urls[e.FullURL] = true
+		switch r := e.Resource.(type) {
+		case FHIRDevice:
+			got = append(got, summary{r.ResourceType, r.ID, "", r.Type.Coding[0].Code, "", 0})
+		case FHIRMedicationAdministration:
+			if r.Device[0].Reference != deviceURL || r.Subject.Reference != "Patient/1" {
+				t.Errorf("%s has wrong device or subject", r.ID)
+			}
+			effective := r.EffectiveDateTime
+			if r.EffectivePeriod != nil {
+				effective = r.EffectivePeriod.Start + "/" + r.EffectivePeriod.End
+			}
+			got = append(got, summary{r.ResourceType, r.ID, r.Status, r.Dosage.Text, effective, r.Dosage.Dose.Value})
+		case FHIRObservation:
+			if r.Device.Reference != deviceURL || r.Subject.Reference != "Patient/1" {
+				t.Errorf("%s has wrong device or subject", r.ID)
+			}
+			got = append(got, summary{r.ResourceType, r.ID, r.Status, r.Code.Coding[0].Code, r.EffectiveDateTime, r.ValueQuantity.Value})
+		default:
+			t.Fatalf("unexpected resource %T", r)
+		}
+	}
+	want := []summary{
+		{"Device", "pump-123456", "", "69805005", "", 0},
+		{"MedicationAdministration", "bolus-" + fhirRecordID(records[8]), "completed", "Normal bolus", "2020-03-10T12:10:00-04:00", 1.5},
+		{"MedicationAdministration", "bolus-" + fhirRecordID(records[7]), "stopped", "Square wave bolus", "2020-03-10T12:20:00-04:00/2020-03-10T12:50:00-04:00", 1},
+		{"Observation", "bg-" + fhirRecordID(records[4]), "final", "41653-7", "2020-03-10T12:45:00-04:00", 140},
+		{"Observation", "bg-" + fhirRecordID(records[0]), "final", "14743-9", "2020-03-10T13:00:00-04:00", 6.5},
+		{"MedicationAdministration", "basal-20200310T120000", "completed", "Scheduled basal", "2020-03-10T12:00:00-04:00/2020-03-10T12:30:00-04:00", 0.5},
+		{"Observation", "cgm-20200310T130500", "final", "99504-3", "2020-03-10T13:05:00-04:00", 150},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FHIRExport = %+v, want %+v", got, want)
+	}
+	for _, s := range got {
+		if !fhirIDPattern.MatchString(s.ID) {
+			t.Errorf("invalid FHIR ID %q", s.ID)
+		}
+	}
+	again := FHIRExport(records, cgm, device, "Patient/1")
+	if !reflect.DeepEqual(again, bundle) {
+		t.Errorf("FHIRExport is not deterministic")
+	}
+}
+
+func TestFHIRDevice(t *testing.T) {
+	d := NewFHIRDevice("554", "VER 2.6A1.1", "654321")
+	if d.DeviceName[0].Name != "MiniMed 554" || d.SerialNumber != "654321" || d.Version[0].Value != "VER 2.6A1.1" {
+		t.Errorf("NewFHIRDevice = %+v", d)
+	}
+	d = NewFHIRDevice("", "", "654321")
+	if d.DeviceName != nil || d.Version != nil {
+		t.Errorf("NewFHIRDevice with unknown model = %+v", d)
+	}
+	bundle := FHIRExport(nil, nil, d, "")
+	if len(bundle.Entry) != 1 || bundle.Timestamp != "" {
+		t.Errorf("FHIRExport of no records = %+v", bundle)
+	}
+}
@@ -0,0 +1,285 @@
+package medtronic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AnomalyKind classifies the problems found by CheckHistoryPages.
+type AnomalyKind string
+
+// Kinds of history anomalies.
+const (
+	UnknownRecord        AnomalyKind = "unknown record"
+	TruncatedRecord      AnomalyKind = "truncated record"
+	ImplausibleTimestamp AnomalyKind = "implausible timestamp"
+	OrphanedTempBasal    AnomalyKind = "temp basal rate without duration"
+	RewindWithoutPrime   AnomalyKind = "rewind without prime"
+)
+
+const (
+	// Timestamps before this year are considered impossible.
+	minPlausibleYear = 2015
+
+	// Allowance for a pump clock that is ahead of the host.
+	maxClockAhead = 24 * time.Hour
+
+	// Number of consecutive records that must decode
+	// in order to resynchronize after unknown bytes.
+	resyncDepth = 3
+)
+
+var errTruncatedRecord = errors.New("record extends past end of page")
+
+// HistoryAnomaly describes a problem found in a history page.
+// Offset is the position in the page of the record or bytes in question.
+// For unknown or truncated records, Data holds the bytes that were skipped
+// and Candidates holds records that were found within them
+// while resynchronizing, the last of which is where decoding resumed.
+type HistoryAnomaly struct {
+	Offset     int
+	Kind       AnomalyKind
+	Message    string
+	Data       []byte            `json:",omitempty"`
+	Record     *HistoryRecord    `json:",omitempty"`
+	Candidates []CandidateRecord `json:",omitempty"`
+}
+
+// CandidateRecord is a record found while resynchronizing after unknown bytes.
+type CandidateRecord struct {
+	Offset int
+	Record HistoryRecord
+}
+
+// HistoryPageReport is the result of checking a history page.
+// The records are in reverse chronological order, as with DecodeHistory,
+// and the anomalies are in order of their offsets.
+type HistoryPageReport struct {
+	Page      int
+	Records   History
+	Anomalies []HistoryAnomaly
+}
+
+type historyChecker struct {
+	family Family
+	now    time.Time
+}
+
+// pageRecord is a record decoded from a page, along with its position.
+type pageRecord struct {
+	page   int
+	offset int
+	record HistoryRecord
+}
+
+// CheckHistoryPages decodes history pages (with page 0 the most recent)
+// without stopping at unknown records, and reports anomalies in each page.
+// Timestamps later than the given time (plus an allowance for clock skew)
+// or before 2015 are reported as implausible.
+// Temp basal rates and rewinds are checked for their matching records
+// across all the pages, so adjacent pages should be checked together.
+func CheckHistoryPages(pages [][]byte, family Family, now time.Time) []HistoryPageReport {
+	c := historyChecker{family: family, now: now}
+	reports := make([]HistoryPageReport, len(pages))
+	var all []pageRecord
+	for page := len(pages) - 1; page >= 0; page-- {
+		records, anomalies := c.scanPage(pages[page])
+		for _, r := range records {
+			all = append(all, pageRecord{page: page, offset: r.Offset, record: r.Record})
+		}
+		reports[page] = HistoryPageReport{Page: page, Anomalies: anomalies}
+		for i := len(records) - 1; i >= 0; i-- {
+			reports[page].Records = append(reports[page].Records, records[i].Record)
+		}
+	}
+	for _, a := range checkSequence(all) {
+		reports[a.page].Anomalies = append(reports[a.page].Anomalies, a.anomaly)
+	}
+	for i := range reports {
+		a := reports[i].Anomalies
+		sort.SliceStable(a, func(i, j int) bool { return a[i].Offset < a[j].Offset })
+	}
+	return reports
+}
+
+// CheckHistoryPage checks a single history page.
+func CheckHistoryPage(data []byte, family Family, now time.Time) HistoryPageReport {
+	return CheckHistoryPages([][]byte{data}, family, now)[0]
+}
+
+// CheckHistory retrieves all the pump's history pages and checks them.
+func (pump *Pump) CheckHistory() []HistoryPageReport {
+	lastPage := pump.LastHistoryPage()
+	if pump.Error() != nil {
+		return nil
+	}
+	var pages [][]byte
+	for page := 0; page <= lastPage; page++ {
+		data := pump.HistoryPage(page)
+		if pump.Error() != nil {
+			break
+		}
+		pages = append(pages, data)
+	}
+	return CheckHistoryPages(pages, pump.Family(), time.Now())
+}
+
+// decodeRecordAt decodes the record at the start of data,
+// returning an error instead of panicking if the data is too short.
+func decodeRecordAt(data []byte, family Family) (r HistoryRecord, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errTruncatedRecord
+		}
+	}()
+	return DecodeHistoryRecord(data, family)
+}
+
+// timestampProblem returns a description of an implausible timestamp,
+// or the empty string if the record's timestamp is plausible or absent.
+func (c historyChecker) timestampProblem(r HistoryRecord) string {
+	t := r.Time
+	switch {
+	case t.IsZero():
+		return ""
+	case t.Year() < minPlausibleYear:
+		return fmt.Sprintf("timestamp %s is before %d", t.Format(UserTimeLayout), minPlausibleYear)
+	case t.After(c.now.Add(maxClockAhead)):
+		return fmt.Sprintf("timestamp %s is in the future", t.Format(UserTimeLayout))
+	}
+	return ""
+}
+
+// scanPage decodes the records in a page in chronological order,
+// resynchronizing after unknown or truncated records.
+func (c historyChecker) scanPage(data []byte) ([]CandidateRecord, []HistoryAnomaly) {
+	var records []CandidateRecord
+	var anomalies []HistoryAnomaly
+	pos := 0
+	for pos < len(data) && !allZero(data[pos:]) {
+		r, err := decodeRecordAt(data[pos:], c.family)
+		if err == nil {
+			records = append(records, CandidateRecord{Offset: pos, Record: r})
+			if msg := c.timestampProblem(r); msg != "" {
+				rec := r
+				anomalies = append(anomalies, HistoryAnomaly{
+					Offset:  pos,
+					Kind:    ImplausibleTimestamp,
+					Message: msg,
+					Record:  &rec,
+				})
+			}
+			pos += len(r.Data)
+			continue
+		}
+		next, candidates := c.resync(data, pos)
+		a := HistoryAnomaly{
+			Offset:     pos,
+			Data:       data[pos:next],
+			Candidates: candidates,
+		}
+		if err == errTruncatedRecord {
+			a.Kind = TruncatedRecord
+			a.Message = fmt.Sprintf("%v record is truncated; skipped %d bytes", HistoryRecordType(data[pos]), next-pos)
+		} else {
+			a.Kind = UnknownRecord
+			a.Message = fmt.Sprintf("unknown record type %02X; skipped %d bytes", data[pos], next-pos)
+		}
+		anomalies = append(anomalies, a)
+		pos = next
+	}
+	return records, anomalies
+}
+
+// resync finds the first position after pos where decoding can resume,
+// along with the plausible records that begin between the two.
+func (c historyChecker) resync(data []byte, pos int) (int, []CandidateRecord) {
+	var candidates []CandidateRecord
+	for i := pos + 1; i < len(data); i++ {
+		if allZero(data[i:]) {
+			return i, candidates
+		}
+		r, err := decodeRecordAt(data[i:], c.family)
+		if err != nil || r.Time.IsZero() || c.timestampProblem(r) != "" {
+			continue
+		}
+		candidates = append(candidates, CandidateRecord{Offset: i, Record: r})
+		if c.chains(data[i:]) {
+			return i, candidates
+		}
+	}
+	return len(data), candidates
+}
+
+// chains reports whether the records at the start of data decode
+// with plausible timestamps up to the resynchronization depth
+// or the end of the page.
+func (c historyChecker) chains(data []byte) bool {
+	pos := 0
+	for n := 0; n < resyncDepth; n++ {
+		if allZero(data[pos:]) {
+			return true
+		}
+		r, err := decodeRecordAt(data[pos:], c.family)
+		if err != nil || c.timestampProblem(r) != "" {
+			return false
+		}
+		pos += len(r.Data)
+	}
+	return true
+}
+
+type pageAnomaly struct {
+	page    int
+	anomaly HistoryAnomaly
+}
+
+// checkSequence checks records (in chronological order) for missing companions:
+// a TempBasalRate record must be followed by a TempBasalDuration record
+// with the same timestamp, and a Rewind must be followed by a Prime
+// before the next Rewind. Records whose companions may not have been
+// written yet, at the end of the sequence, are not reported.
+func checkSequence(all []pageRecord) []pageAnomaly {
+	var anomalies []pageAnomaly
+	add := func(e pageRecord, kind AnomalyKind, msg string) {
+		r := e.record
+		anomalies = append(anomalies, pageAnomaly{
+			page:    e.page,
+			anomaly: HistoryAnomaly{Offset: e.offset, Kind: kind, Message: msg, Record: &r},
+		})
+	}
+	for i, e := range all {
+		if i == len(all)-1 {
+			break
+		}
+		r := e.record
+		switch r.Type() {
+		case TempBasalRate:
+			next := all[i+1].record
+			if next.Type() != TempBasalDuration || !next.Time.Equal(r.Time) {
+				add(e, OrphanedTempBasal, fmt.Sprintf("temp basal rate at %s is followed by %v", r.Time.Format(UserTimeLayout), next.Type()))
+			}
+		case Rewind:
+			if rewoundBeforePrime(all[i+1:]) {
+				add(e, RewindWithoutPrime, fmt.Sprintf("rewind at %s is not followed by a prime", r.Time.Format(UserTimeLayout)))
+			}
+		}
+	}
+	return anomalies
+}
+
+// rewoundBeforePrime reports whether a Rewind occurs in the records
+// (in chronological order) before any Prime.
+func rewoundBeforePrime(records []pageRecord) bool {
+	for _, e := range records {
+		switch e.record.Type() {
+		case Prime:
+			return false
+		case Rewind:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package medtronic
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckHistoryPageData(t *testing.T) {
+	now := parseTime("2020-03-10T12:00:00")
+	for _, c := range encodeTestCases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			f, err := os.Open(testFile + ".data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := readBytes(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			family := testPumpFamily(c)
+			decoded, err := DecodeHistory(data, family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := CheckHistoryPage(data, family, now)
+			if !reflect.DeepEqual(report.Records, decoded) {
+				t.Errorf("CheckHistoryPage returned %d records, want %d", len(report.Records), len(decoded))
+			}
+			for _, a := range report.Anomalies {
+				switch a.Kind {
+				case ImplausibleTimestamp:
+					// Pumps whose clocks have not been set write records from years ago.
+					if a.Record.Time.Year() >= minPlausibleYear {
+						t.Errorf("unexpected anomaly %+v", a)
+					}
+				case RewindWithoutPrime:
+					if c.testBase != "model" {
+						t.Errorf("unexpected anomaly %+v", a)
+					}
+				default:
+					t.Errorf("unexpected anomaly %+v", a)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckHistoryPages(t *testing.T) {
+	const family = 23
+	now := parseTime("2020-03-10T12:00:00")
+	record := func(typ HistoryRecordType, ts string, info interface{}) []byte {
+		r, err := NewHistoryRecord(typ, parseTime(ts), info, family)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Data
+	}
+	join := func(records ...[]byte) []byte {
+		var data []byte
+		for _, r := range records {
+			data = append(data, r...)
+		}
+		return data
+	}
+	rate := TempBasalRecord{Type: Absolute, Value: Insulin(1000)}
+	duration := Duration(30 * time.Minute)
+	garbage := []byte{0xFF, 0xEE, 0xDD}
+	// Records in each page are in chronological order.
+	older := join(
+		record(Rewind, "2020-03-09T08:00:00", nil),
+		record(Rewind, "2020-03-09T08:05:00", nil),
+		record(Prime, "2020-03-09T08:10:00", PrimeRecord{Manual: 10000}),
+		record(TempBasalRate, "2020-03-09T09:00:00", rate),
+		record(SuspendPump, "2020-03-09T09:30:00", nil),
+		record(TempBasalRate, "2020-03-09T10:00:00", rate),
+	)
+	newer := join(
+		record(TempBasalDuration, "2020-03-09T10:00:00", duration),
+		garbage,
+		record(ResumePump, "2020-03-09T11:00:00", nil),
+		record(Rewind, "2020-03-10T08:00:00", nil),
+		record(Prime, "2020-03-10T08:05:00", PrimeRecord{Fixed: 500}),
+		record(SuspendPump, "2010-01-01T00:00:00", nil),
+		record(ResumePump, "2020-03-20T12:00:00", nil),
+		// Truncated record.
+		record(TempBasalRate, "2020-03-10T09:00:00", rate)[:5],
+	)
+	reports := CheckHistoryPages([][]byte{newer, older}, family, now)
+	type anomaly struct {
+		offset int
+		kind   AnomalyKind
+	}
+	cases := []struct {
+		records   int
+		anomalies []anomaly
+	}{
+		{6, []anomaly{
+			{7, UnknownRecord},
+			{34, ImplausibleTimestamp},
+			{41, ImplausibleTimestamp},
+			{48, TruncatedRecord},
+		}},
+		{6, []anomaly{
+			{0, RewindWithoutPrime},
+			{24, OrphanedTempBasal},
+		}},
+	}
+	for i, c := range cases {
+		r := reports[i]
+		if r.Page != i {
+			t.Errorf("report %d is for page %d", i, r.Page)
+		}
+		if len(r.Records) != c.records {
+			t.Errorf("page %d: %d records, want %d", i, len(r.Records), c.records)
+		}
+		var got []anomaly
+		for _, a := range r.Anomalies {
+			got = append(got, anomaly{a.Offset, a.Kind})
+		}
+		if !reflect.DeepEqual(got, c.anomalies) {
+			t.Errorf("page %d: anomalies = %v, want %v", i, got, c.anomalies)
+		}
+	}
+	unknown := reports[0].Anomalies[0]
+	if !reflect.DeepEqual(unknown.Data, garbage) {
+		t.Errorf("unknown bytes = % X, want % X", unknown.Data, garbage)
+	}
+	n := len(unknown.Candidates)
+	if n == 0 || unknown.Candidates[n-1].Offset != 10 || unknown.Candidates[n-1].Record.Type() != ResumePump {
+		t.Errorf("candidates = %+v, want ResumePump record at offset 10", unknown.Candidates)
+	}
+}
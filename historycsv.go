@@ -0,0 +1,272 @@
+package medtronic
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVTimeLayout is the layout for CSV timestamps, which spreadsheets recognize.
+const CSVTimeLayout = "2006-01-02 15:04:05"
+
+// CSVOptions controls the time zone and units of CSV exports.
+// Timestamps are converted to Location if it is non-nil.
+// Glucose values are converted to GlucoseUnits (mg/dL by default)
+// and carb values to CarbUnits (grams by default).
+type CSVOptions struct {
+	Location     *time.Location
+	GlucoseUnits GlucoseUnitsType
+	CarbUnits    CarbUnitsType
+}
+
+// ParseCSVOptions returns CSV options for the given time zone name
+// ("" for the pump's time zone), glucose units ("mg/dL" or "mmol/L"),
+// and carb units ("g" or "exch").
+func ParseCSVOptions(zone string, glucoseUnits string, carbUnits string) (CSVOptions, error) {
+	var opts CSVOptions
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return opts, err
+		}
+		opts.Location = loc
+	}
+	switch strings.ToLower(glucoseUnits) {
+	case "", "mg/dl":
+		opts.GlucoseUnits = MgPerDeciLiter
+	case "mmol", "mmol/l":
+		opts.GlucoseUnits = MMolPerLiter
+	default:
+		return opts, fmt.Errorf("unknown glucose units %q", glucoseUnits)
+	}
+	switch strings.ToLower(carbUnits) {
+	case "", "g", "grams":
+		opts.CarbUnits = Grams
+	case "exch", "exchanges":
+		opts.CarbUnits = Exchanges
+	default:
+		return opts, fmt.Errorf("unknown carb units %q", carbUnits)
+	}
+	return opts, nil
+}
+
+func (opts CSVOptions) withDefaults() (CSVOptions, error) {
+	switch opts.GlucoseUnits {
+	case 0:
+		opts.GlucoseUnits = MgPerDeciLiter
+	case MgPerDeciLiter, MMolPerLiter:
+	default:
+		return opts, fmt.Errorf("unknown glucose unit %d", opts.GlucoseUnits)
+	}
+	switch opts.CarbUnits {
+	case 0:
+		opts.CarbUnits = Grams
+	case Grams, Exchanges:
+	default:
+		return opts, fmt.Errorf("unknown carb unit %d", opts.CarbUnits)
+	}
+	return opts, nil
+}
+
+// Columns of CSV exports.
+const (
+	csvTime = iota
+	csvType
+	csvInsulin
+	csvProgrammed
+	csvDuration
+	csvCarbs
+	csvGlucose
+	csvRate
+	csvPercent
+	csvTargetLow
+	csvTargetHigh
+	csvSensitivity
+	csvCarbRatio
+	csvCorrection
+	csvFood
+	csvUnabsorbed
+	csvColumns
+)
+
+func (opts CSVOptions) header() []string {
+	bg := "mg/dL"
+	if opts.GlucoseUnits == MMolPerLiter {
+		bg = "mmol/L"
+	}
+	carbs, ratio := "g", "g/U"
+	if opts.CarbUnits == Exchanges {
+		carbs, ratio = "exch", "U/exch"
+	}
+	return []string{
+		"Time",
+		"Type",
+		"Insulin (U)",
+		"Programmed (U)",
+		"Duration (min)",
+		"Carbs (" + carbs + ")",
+		"BG (" + bg + ")",
+		"Rate (U/h)",
+		"Percent",
+		"Target Low (" + bg + ")",
+		"Target High (" + bg + ")",
+		"Sensitivity (" + bg + "/U)",
+		"Carb Ratio (" + ratio + ")",
+		"Correction (U)",
+		"Food (U)",
+		"Unabsorbed (U)",
+	}
+}
+
+func (opts CSVOptions) time(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if opts.Location != nil {
+		t = t.In(opts.Location)
+	}
+	return t.Format(CSVTimeLayout)
+}
+
+// glucose converts a glucose value to the output units.
+// Zero values are left empty.
+func (opts CSVOptions) glucose(g Glucose, t GlucoseUnitsType) string {
+	if g == 0 {
+		return ""
+	}
+	if opts.GlucoseUnits == MgPerDeciLiter {
+		return strconv.Itoa(mgPerDeciLiter(g, t))
+	}
+	mmol := float64(g) / mgPerDeciLiterPerMMol
+	if t == MMolPerLiter {
+		// Glucose values in mmol/L are represented in μmol/L.
+		mmol = float64(g) / 1000
+	}
+	return strconv.FormatFloat(mmol, 'f', 1, 64)
+}
+
+// carbs converts a carb value to the output units.
+func (opts CSVOptions) carbs(c Carbs, t CarbUnitsType) string {
+	grams := carbGrams(c, t)
+	if opts.CarbUnits == Exchanges {
+		return strconv.FormatFloat(grams/gramsPerExchange, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(grams, 'f', 0, 64)
+}
+
+// ratio converts a carb ratio to the output units.
+func (opts CSVOptions) ratio(r Ratio, t CarbUnitsType) string {
+	if r == 0 {
+		return ""
+	}
+	g := gramsPerUnit(r, t)
+	if opts.CarbUnits == Exchanges {
+		return strconv.FormatFloat(gramsPerExchange/g, 'f', 3, 64)
+	}
+	return strconv.FormatFloat(g, 'f', 1, 64)
+}
+
+func minutes(d Duration) string {
+	return strconv.Itoa(int(d / Duration(time.Minute)))
+}
+
+// historyRow returns the CSV fields for a history record.
+func (opts CSVOptions) historyRow(r HistoryRecord) []string {
+	row := make([]string, csvColumns)
+	row[csvTime] = opts.time(r.Time)
+	row[csvType] = r.Type().String()
+	switch info := r.Info.(type) {
+	case Insulin:
+		if r.Type() == MaxBasal {
+			row[csvRate] = info.String()
+		} else {
+			row[csvInsulin] = info.String()
+		}
+	case Duration:
+		row[csvDuration] = minutes(info)
+	case GlucoseRecord:
+		row[csvGlucose] = opts.glucose(info.Glucose, info.Units)
+	case CarbRecord:
+		row[csvCarbs] = opts.carbs(info.Carbs, info.Units)
+	case TempBasalRecord:
+		switch v := info.Value.(type) {
+		case Insulin:
+			row[csvRate] = v.String()
+		case int:
+			row[csvPercent] = strconv.Itoa(v)
+		}
+	case BasalProfileStartRecord:
+		row[csvRate] = info.BasalRate.Rate.String()
+	case PrimeRecord:
+		row[csvInsulin] = (info.Fixed + info.Manual).String()
+	case BolusRecord:
+		row[csvInsulin] = info.Amount.String()
+		row[csvProgrammed] = info.Programmed.String()
+		row[csvDuration] = minutes(info.Duration)
+		row[csvUnabsorbed] = info.Unabsorbed.String()
+	case BolusWizardRecord:
+		row[csvInsulin] = info.Bolus.String()
+		row[csvCarbs] = opts.carbs(info.CarbInput, info.CarbUnits)
+		row[csvGlucose] = opts.glucose(info.GlucoseInput, info.GlucoseUnits)
+		row[csvTargetLow] = opts.glucose(info.TargetLow, info.GlucoseUnits)
+		row[csvTargetHigh] = opts.glucose(info.TargetHigh, info.GlucoseUnits)
+		row[csvSensitivity] = opts.glucose(info.Sensitivity, info.GlucoseUnits)
+		row[csvCarbRatio] = opts.ratio(info.CarbRatio, info.CarbUnits)
+		row[csvCorrection] = info.Correction.String()
+		row[csvFood] = info.Food.String()
+		row[csvUnabsorbed] = info.Unabsorbed.String()
+	case DailyTotalRecord:
+		row[csvInsulin] = info.Total.String()
+		if info.Carbs != 0 {
+			row[csvCarbs] = opts.carbs(info.Carbs, Grams)
+		}
+		row[csvGlucose] = opts.glucose(info.BGAverage, MgPerDeciLiter)
+	}
+	return row
+}
+
+// cgmRow returns the CSV fields for a CGM record.
+func (opts CSVOptions) cgmRow(r CGMRecord) []string {
+	row := make([]string, csvColumns)
+	row[csvTime] = opts.time(r.Time)
+	row[csvType] = r.Type.String()
+	row[csvGlucose] = opts.glucose(Glucose(r.Glucose), MgPerDeciLiter)
+	return row
+}
+
+func writeCSV(w io.Writer, opts CSVOptions, n int, row func(CSVOptions, int) []string) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(opts.header()); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := cw.Write(row(opts, i)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHistoryCSV writes history records as CSV, with a header row
+// followed by one row per record, in the same order as the records.
+func WriteHistoryCSV(w io.Writer, records History, opts CSVOptions) error {
+	return writeCSV(w, opts, len(records), func(opts CSVOptions, i int) []string {
+		return opts.historyRow(records[i])
+	})
+}
+
+// WriteCGMHistoryCSV writes CGM records as CSV, using the same columns
+// as WriteHistoryCSV so that the results can be combined.
+func WriteCGMHistoryCSV(w io.Writer, records CGMHistory, opts CSVOptions) error {
+	return writeCSV(w, opts, len(records), func(opts CSVOptions, i int) []string {
+		return opts.cgmRow(records[i])
+	})
+}
@@ -0,0 +1,95 @@
+package medtronic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteHistoryCSV(t *testing.T) {
+	utc := parseTime("2020-03-10T12:00:00").UTC().Format(CSVTimeLayout)
+	wizard := BolusWizardRecord{
+		GlucoseInput: 180,
+		CarbInput:    45,
+		GlucoseUnits: MgPerDeciLiter,
+		CarbUnits:    Grams,
+		TargetLow:    90,
+		TargetHigh:   126,
+		Sensitivity:  54,
+		CarbRatio:    150,
+		Correction:   1000,
+		Food:         3000,
+		Bolus:        4000,
+	}
+	// Records in reverse chronological order.
+	records := History{
+		testDose(DailyTotal523, "2020-03-10T00:00:00", DailyTotalRecord{Total: 24000, Carbs: 180, BGAverage: 108}),
+		testDose(TempBasalDuration, "2020-03-10T12:30:00", Duration(30*time.Minute)),
+		testDose(TempBasalRate, "2020-03-10T12:30:00", TempBasalRecord{Type: Percent, Value: 150}),
+		testDose(BGCapture, "2020-03-10T12:10:00", GlucoseRecord{Units: MMolPerLiter, Glucose: 6500}),
+		testDose(Bolus, "2020-03-10T12:05:00", BolusRecord{Programmed: 4000, Amount: 3500, Duration: Duration(time.Hour)}),
+		testDose(BolusWizard, "2020-03-10T12:05:00", wizard),
+		testRecord(Rewind, "2020-03-10T12:00:00"),
+	}
+	want := `Time,Type,Insulin (U),Programmed (U),Duration (min),Carbs (g),BG (mg/dL),Rate (U/h),Percent,Target Low (mg/dL),Target High (mg/dL),Sensitivity (mg/dL/U),Carb Ratio (g/U),Correction (U),Food (U),Unabsorbed (U)
+2020-03-10 00:00:00,DailyTotal523,24.000,,,180,108,,,,,,,,,
+2020-03-10 12:30:00,TempBasalDuration,,,30,,,,,,,,,,,
+2020-03-10 12:30:00,TempBasalRate,,,,,,,150,,,,,,,
+2020-03-10 12:10:00,BGCapture,,,,,117,,,,,,,,,
+2020-03-10 12:05:00,Bolus,3.500,4.000,60,,,,,,,,,,,0.000
+2020-03-10 12:05:00,BolusWizard,4.000,,,45,180,,,90,126,54,15.0,1.000,3.000,0.000
+2020-03-10 12:00:00,Rewind,,,,,,,,,,,,,,
+`
+	var buf bytes.Buffer
+	if err := WriteHistoryCSV(&buf, records, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("WriteHistoryCSV = %s, want %s", buf.String(), want)
+	}
+	buf.Reset()
+	opts := CSVOptions{Location: time.UTC, GlucoseUnits: MMolPerLiter, CarbUnits: Exchanges}
+	if err := WriteHistoryCSV(&buf, records, opts); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	header := "Time,Type,Insulin (U),Programmed (U),Duration (min),Carbs (exch),BG (mmol/L),Rate (U/h),Percent,Target Low (mmol/L),Target High (mmol/L),Sensitivity (mmol/L/U),Carb Ratio (U/exch),Correction (U),Food (U),Unabsorbed (U)"
+	if lines[0] != header {
+		t.Errorf("header = %s, want %s", lines[0], header)
+	}
+	rows := []struct {
+		index int
+		want  string
+	}{
+		{4, ",BGCapture,,,,,6.5,"},
+		{6, ",BolusWizard,4.000,,,3.0,10.0,,,5.0,7.0,3.0,1.000,1.000,"},
+		{7, utc + ",Rewind,"},
+	}
+	for _, r := range rows {
+		if !strings.Contains(lines[r.index], r.want) {
+			t.Errorf("row %s does not contain %s", lines[r.index], r.want)
+		}
+	}
+	if err := WriteHistoryCSV(&buf, records, CSVOptions{GlucoseUnits: 3}); err == nil {
+		t.Errorf("WriteHistoryCSV with invalid units succeeded")
+	}
+}
+
+func TestWriteCGMHistoryCSV(t *testing.T) {
+	records := CGMHistory{
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T12:05:00"), Glucose: 180},
+		{Type: CGMSync, Time: parseTime("2020-03-10T12:00:00")},
+	}
+	want := `Time,Type,Insulin (U),Programmed (U),Duration (min),Carbs (g),BG (mmol/L),Rate (U/h),Percent,Target Low (mmol/L),Target High (mmol/L),Sensitivity (mmol/L/U),Carb Ratio (g/U),Correction (U),Food (U),Unabsorbed (U)
+2020-03-10 12:05:00,CGMGlucose,,,,,10.0,,,,,,,,,
+2020-03-10 12:00:00,CGMSync,,,,,,,,,,,,,,
+`
+	var buf bytes.Buffer
+	if err := WriteCGMHistoryCSV(&buf, records, CSVOptions{GlucoseUnits: MMolPerLiter}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("WriteCGMHistoryCSV = %s, want %s", buf.String(), want)
+	}
+}
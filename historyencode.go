@@ -0,0 +1,1132 @@
+package medtronic
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ecc1/medtronic/packet"
+)
+
+// Size of the records in a history page, excluding the CRC.
+const historyPageDataSize = 1022
+
+type encoder func(HistoryRecord, Family) ([]byte, error)
+
+var encode = map[HistoryRecordType]encoder{
+	Bolus:                   encodeBolusRecord,
+	Prime:                   encodePrime,
+	Alarm:                   encodeAlarm,
+	DailyTotal:              encodeDailyTotal,
+	BasalProfileBefore:      encodeBasalProfile,
+	BasalProfileAfter:       encodeBasalProfile,
+	BGCapture:               encodeBGCapture,
+	SensorAlarm:             encodeSensorAlarm,
+	ClearAlarm:              encodeValue,
+	ChangeBasalPattern:      encodeValue,
+	TempBasalDuration:       encodeTempBasalDuration,
+	ChangeTime:              encodeBase,
+	NewTime:                 encodeBase,
+	LowBattery:              encodeBase,
+	BatteryChange:           encodeBase,
+	SetAutoOff:              encodeSetAutoOff,
+	PrepareInsulinChange:    encodeBase,
+	SuspendPump:             encodeBase,
+	ResumePump:              encodeBase,
+	SelfTest:                encodeBase,
+	Rewind:                  encodeBase,
+	ClearSettings:           encodeBase,
+	EnableChildBlock:        encodeEnable,
+	MaxBolus:                encodeInsulinValue,
+	EnableRemote:            encodeEnableN(21),
+	MaxBasal:                encodeInsulinValue,
+	EnableBolusWizard:       encodeEnable,
+	Unknown2E:               encodeUnknown2E,
+	BolusWizard512:          encodeBolusWizard512,
+	UnabsorbedInsulin512:    encodeUnabsorbedInsulin,
+	ChangeBGReminder:        encodeBase,
+	SetAlarmClockTime:       encodeBase,
+	TempBasalRate:           encodeTempBasalRate,
+	LowReservoir:            encodeLowReservoir,
+	AlarmClock:              encodeBase,
+	ChangeMeterID:           encodeBaseN(21),
+	BGReceived512:           encodeBGReceived,
+	ConfirmInsulinChange:    encodeEnable,
+	SensorStatus:            encodeEnable,
+	EnableMeter:             encodeEnableN(21),
+	BGReceived:              encodeBGReceived,
+	MealMarker:              encodeMealMarker,
+	ExerciseMarker:          encodeBaseN(8),
+	InsulinMarker:           encodeInsulinMarker,
+	OtherMarker:             encodeBase,
+	EnableSensorAutoCal:     encodeBase,
+	ChangeBolusWizardSetup:  encodeBaseN(39),
+	SensorSetup:             encodeSensorSetup,
+	Sensor51:                encodeBase,
+	Sensor52:                encodeBase,
+	ChangeSensorAlarm:       encodeChangeSensorAlarm,
+	Sensor54:                encodeBaseN(64),
+	Sensor55:                encodeSensor55,
+	ChangeSensorAlert:       encodeBaseN(12),
+	ChangeBolusStep:         encodeBase,
+	BolusWizardSetup:        encodeBolusWizardSetup,
+	BolusWizard:             encodeBolusWizard,
+	UnabsorbedInsulin:       encodeUnabsorbedInsulin,
+	SaveSettings:            encodeBase,
+	EnableVariableBolus:     encodeEnable,
+	ChangeEasyBolus:         encodeBase,
+	EnableBGReminder:        encodeEnable,
+	EnableAlarmClock:        encodeEnable,
+	ChangeTempBasalType:     encodeChangeTempBasalType,
+	ChangeAlarmType:         encodeValue,
+	ChangeTimeFormat:        encodeValue,
+	ChangeReservoirWarning:  encodeChangeReservoirWarning,
+	EnableBolusReminder:     encodeEnable,
+	SetBolusReminderTime:    encodeEnableN(9),
+	DeleteBolusReminderTime: encodeEnableN(9),
+	BolusReminder:           encodeBaseN(9),
+	DeleteAlarmClockTime:    encodeBase,
+	DailyTotal515:           encodeDailyTotalSummary(38),
+	DailyTotal522:           encodeDailyTotalSummary(44),
+	DailyTotal523:           encodeDailyTotalSummary(52),
+	ChangeCarbUnits:         encodeValue,
+	BasalProfileStart:       encodeBasalProfileStart,
+	ConnectOtherDevices:     encodeEnable,
+	ChangeOtherDevice:       encodeBaseN(37),
+	ChangeMarriage:          encodeBaseN(12),
+	DeleteOtherDevice:       encodeBaseN(12),
+	EnableCaptureEvent:      encodeEnable,
+}
+
+// EncodeHistoryRecord encodes a history record in the pump's format,
+// from its type (the first byte of its Data), Time, and Info.
+// If Data holds the original contents of the record,
+// bytes whose meaning is not known are preserved;
+// otherwise they are set to zero.
+// If the record's time has been normalized, its original pump time is used.
+func EncodeHistoryRecord(r HistoryRecord, family Family) ([]byte, error) {
+	if len(r.Data) == 0 {
+		return nil, fmt.Errorf("history record has no type")
+	}
+	encoder := encode[r.Type()]
+	if encoder == nil {
+		return nil, unknownRecord(r.Data)
+	}
+	return encoder(r, family)
+}
+
+// NewHistoryRecord returns the history record that the pump would write
+// for an event of the given type, time, and info.
+func NewHistoryRecord(t HistoryRecordType, ts time.Time, info interface{}, family Family) (HistoryRecord, error) {
+	data, err := EncodeHistoryRecord(HistoryRecord{Data: []byte{byte(t)}, Time: ts, Info: info}, family)
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	return DecodeHistoryRecord(data, family)
+}
+
+// EncodeHistoryPage encodes history records (in reverse chronological order)
+// as a 1024-byte history page: the records in chronological order,
+// padded with zeros, followed by a CRC-16.
+func EncodeHistoryPage(records History, family Family) ([]byte, error) {
+	data, err := encodeRecords(records, family)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > historyPageDataSize {
+		return nil, fmt.Errorf("history records (%d bytes) do not fit in a page", len(data))
+	}
+	return encodePage(data), nil
+}
+
+// EncodeHistoryPages encodes history records (in reverse chronological order)
+// as a sequence of history pages, filling each page in turn
+// the way the pump does. As with Pump.HistoryPage,
+// page 0 holds the most recent records and may be partially filled.
+func EncodeHistoryPages(records History, family Family) ([][]byte, error) {
+	var pages [][]byte
+	var data []byte
+	for i := len(records) - 1; i >= 0; i-- {
+		rec, err := EncodeHistoryRecord(records[i], family)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) > historyPageDataSize {
+			return nil, fmt.Errorf("%v record is too long (%d bytes)", records[i].Type(), len(rec))
+		}
+		if len(data)+len(rec) > historyPageDataSize {
+			pages = append(pages, encodePage(data))
+			data = nil
+		}
+		data = append(data, rec...)
+	}
+	pages = append(pages, encodePage(data))
+	for i, j := 0, len(pages)-1; i < j; i, j = i+1, j-1 {
+		pages[i], pages[j] = pages[j], pages[i]
+	}
+	return pages, nil
+}
+
+func encodeRecords(records History, family Family) ([]byte, error) {
+	var data []byte
+	for i := len(records) - 1; i >= 0; i-- {
+		rec, err := EncodeHistoryRecord(records[i], family)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, rec...)
+	}
+	return data, nil
+}
+
+func encodePage(data []byte) []byte {
+	page := make([]byte, historyPageDataSize, historyPageDataSize+2)
+	copy(page, data)
+	return append(page, marshalUint16(packet.CRC16(page))...)
+}
+
+// recordBuffer returns a buffer for encoding a record of the given length,
+// initialized with the record's original data.
+func recordBuffer(r HistoryRecord, length int) []byte {
+	data := make([]byte, length)
+	copy(data, r.Data)
+	return data
+}
+
+func infoError(r HistoryRecord) error {
+	return fmt.Errorf("%v record has unexpected info (%T)", r.Type(), r.Info)
+}
+
+// recordTime returns the time to encode in a record, in the pump's time zone.
+func recordTime(r HistoryRecord) time.Time {
+	t := r.Time
+	if !r.PumpTime.IsZero() {
+		t = r.PumpTime
+	}
+	return t.In(time.Local)
+}
+
+// putTime encodes a 5-byte timestamp, preserving the bits
+// that some records use for other values.
+func putTime(data []byte, r HistoryRecord) error {
+	if r.Time.IsZero() {
+		return fmt.Errorf("%v record has no timestamp", r.Type())
+	}
+	t := recordTime(r)
+	if t.Year() < 2000 || 2000+0x7F < t.Year() {
+		return fmt.Errorf("%v record has out-of-range timestamp (%v)", r.Type(), t)
+	}
+	month := byte(t.Month())
+	data[0] = byte(t.Second()) | (month>>2)<<6
+	data[1] = byte(t.Minute()) | (month&0x3)<<6
+	data[2] = data[2]&^0x1F | byte(t.Hour())
+	data[3] = data[3]&^0x1F | byte(t.Day())
+	data[4] = data[4]&^0x7F | byte(t.Year()-2000)
+	return nil
+}
+
+// putDate encodes a 2-byte date.
+func putDate(data []byte, r HistoryRecord) error {
+	if r.Time.IsZero() {
+		return fmt.Errorf("%v record has no date", r.Type())
+	}
+	t := recordTime(r)
+	if t.Year() < 2000 || 2000+0x7F < t.Year() {
+		return fmt.Errorf("%v record has out-of-range date (%v)", r.Type(), t)
+	}
+	month := byte(t.Month())
+	data[0] = byte(t.Day()) | (month>>1)<<5
+	data[1] = (month&0x1)<<7 | byte(t.Year()-2000)
+	return nil
+}
+
+// checkRange returns an error if n cannot be represented in the given number of bits.
+func checkRange(kind string, n int, bits uint) error {
+	if n < 0 || 1<<bits <= n {
+		return fmt.Errorf("%s (%d) is out of range", kind, n)
+	}
+	return nil
+}
+
+// insulinToStrokes converts an insulin amount to pump strokes
+// that fit in the given number of bits.
+func insulinToStrokes(kind string, v Insulin, family Family, bits uint) (int, error) {
+	per := milliUnitsPerStroke(family)
+	if v%per != 0 {
+		return 0, fmt.Errorf("%s (%d) is not a multiple of %d milliUnits", kind, v, per)
+	}
+	n := int(v / per)
+	return n, checkRange(kind, n, bits)
+}
+
+func putTwoByteInsulin(data []byte, kind string, v Insulin, family Family) error {
+	n, err := insulinToStrokes(kind, v, family, 15)
+	if err != nil {
+		return err
+	}
+	copy(data, marshalUint16(uint16(n)))
+	return nil
+}
+
+// durationToUnits converts a duration to a whole number of the given units
+// that fits in the given number of bits.
+func durationToUnits(kind string, d Duration, unit time.Duration, bits uint) (int, error) {
+	if time.Duration(d)%unit != 0 {
+		return 0, fmt.Errorf("%s (%v) is not a multiple of %v", kind, time.Duration(d), unit)
+	}
+	n := int(time.Duration(d) / unit)
+	return n, checkRange(kind, n, bits)
+}
+
+// glucoseToBits converts a glucose value to the pump's representation
+// in the given number of bits.
+func glucoseToBits(kind string, g Glucose, units GlucoseUnitsType, bits uint) (int, error) {
+	n, err := glucoseToInt(kind, g, units)
+	if err != nil {
+		return 0, err
+	}
+	return n, checkRange(kind, n, bits)
+}
+
+// ratioToBits converts a carb ratio to the pump's representation
+// in the given number of bits.
+func ratioToBits(r Ratio, units CarbUnitsType, family Family, bits uint) (int, error) {
+	n, err := ratioToInt(r, units, family)
+	if err != nil {
+		return 0, err
+	}
+	return n, checkRange("carb ratio", n, bits)
+}
+
+func timeOfDayToHalfHours(t TimeOfDay) (byte, error) {
+	if time.Duration(t)%(30*time.Minute) != 0 {
+		return 0, fmt.Errorf("schedule start (%v) is not a multiple of 30 minutes", t)
+	}
+	return t.HalfHours(), nil
+}
+
+// withBase returns an encoder for records of the given length
+// with a timestamp in bytes 2 to 6, followed by the encoding of their info.
+func withBase(length int, f func([]byte, HistoryRecord, Family) error) encoder {
+	return func(r HistoryRecord, family Family) ([]byte, error) {
+		data := recordBuffer(r, length)
+		err := putTime(data[2:7], r)
+		if err == nil && f != nil {
+			err = f(data, r, family)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+func encodeBaseN(length int) encoder {
+	return withBase(length, nil)
+}
+
+var encodeBase = encodeBaseN(7)
+
+func putEnable(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(bool)
+	if !ok {
+		return infoError(r)
+	}
+	if (data[1] != 0) != v {
+		data[1] = 0
+		if v {
+			data[1] = 1
+		}
+	}
+	return nil
+}
+
+func encodeEnableN(length int) encoder {
+	return withBase(length, putEnable)
+}
+
+var encodeEnable = encodeEnableN(7)
+
+var encodeValue = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(int)
+	if !ok {
+		return infoError(r)
+	}
+	if err := checkRange("value", v, 8); err != nil {
+		return err
+	}
+	data[1] = byte(v)
+	return nil
+})
+
+var encodeInsulinValue = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(Insulin)
+	if !ok {
+		return infoError(r)
+	}
+	n, err := insulinToStrokes(r.Type().String(), v, 23, 8)
+	data[1] = byte(n)
+	return err
+})
+
+func encodeBolusRecord(r HistoryRecord, family Family) ([]byte, error) {
+	b, ok := r.Info.(BolusRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	n, err := durationToUnits("bolus duration", b.Duration, 30*time.Minute, 8)
+	if err != nil {
+		return nil, err
+	}
+	if family <= 22 {
+		data := recordBuffer(r, 9)
+		if err := putTime(data[4:9], r); err != nil {
+			return nil, err
+		}
+		programmed, err := insulinToStrokes("programmed bolus", b.Programmed, family, 8)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := insulinToStrokes("bolus amount", b.Amount, family, 8)
+		if err != nil {
+			return nil, err
+		}
+		if b.Unabsorbed != 0 {
+			return nil, fmt.Errorf("unabsorbed insulin is not recorded by x%d pumps", family)
+		}
+		data[1] = byte(programmed)
+		data[2] = byte(amount)
+		data[3] = byte(n)
+		return data, nil
+	}
+	data := recordBuffer(r, 13)
+	if err := putTime(data[8:13], r); err != nil {
+		return nil, err
+	}
+	if err := putTwoByteInsulin(data[1:3], "programmed bolus", b.Programmed, family); err != nil {
+		return nil, err
+	}
+	if err := putTwoByteInsulin(data[3:5], "bolus amount", b.Amount, family); err != nil {
+		return nil, err
+	}
+	if err := putTwoByteInsulin(data[5:7], "unabsorbed insulin", b.Unabsorbed, family); err != nil {
+		return nil, err
+	}
+	data[7] = byte(n)
+	return data, nil
+}
+
+func encodePrime(r HistoryRecord, family Family) ([]byte, error) {
+	p, ok := r.Info.(PrimeRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	data := recordBuffer(r, 10)
+	if err := putTime(data[5:10], r); err != nil {
+		return nil, err
+	}
+	fixed, err := insulinToStrokes("fixed prime", p.Fixed, 22, 8)
+	if err != nil {
+		return nil, err
+	}
+	manual, err := insulinToStrokes("manual prime", p.Manual, 22, 8)
+	if err != nil {
+		return nil, err
+	}
+	data[2] = byte(fixed)
+	data[4] = byte(manual)
+	return data, nil
+}
+
+func encodeAlarm(r HistoryRecord, family Family) ([]byte, error) {
+	code, ok := r.Info.(AlarmCode)
+	if !ok {
+		return nil, infoError(r)
+	}
+	data := recordBuffer(r, 9)
+	if err := putTime(data[4:9], r); err != nil {
+		return nil, err
+	}
+	data[1] = byte(code)
+	return data, nil
+}
+
+func encodeDailyTotal(r HistoryRecord, family Family) ([]byte, error) {
+	total, ok := r.Info.(Insulin)
+	if !ok {
+		return nil, infoError(r)
+	}
+	length := 10
+	if family <= 22 {
+		length = 7
+	}
+	data := recordBuffer(r, length)
+	if err := putDate(data[5:7], r); err != nil {
+		return nil, err
+	}
+	return data, putTwoByteInsulin(data[3:5], "daily total", total, 23)
+}
+
+func putBasalRate(data []byte, b BasalRate) error {
+	start, err := timeOfDayToHalfHours(b.Start)
+	if err != nil {
+		return err
+	}
+	n, err := insulinToStrokes("basal rate", b.Rate, 23, 15)
+	if err != nil {
+		return err
+	}
+	data[0] = start
+	copy(data[1:3], marshalUint16LE(uint16(n)))
+	return nil
+}
+
+var encodeBasalProfile = withBase(152, func(data []byte, r HistoryRecord, family Family) error {
+	sched, ok := r.Info.(BasalRateSchedule)
+	if !ok {
+		return infoError(r)
+	}
+	if len(sched) > maxBasalEntries {
+		return fmt.Errorf("basal profile has too many entries (%d)", len(sched))
+	}
+	body := data[7:151]
+	for i, b := range sched {
+		if err := putBasalRate(body[3*i:], b); err != nil {
+			return err
+		}
+	}
+	rest := body[3*len(sched):]
+	switch {
+	case len(rest) == 0:
+	case len(sched) == 0:
+		rest[0] = 0x3F
+	case rest[0] != 0x3F && (rest[0] != 0 || rest[1] != 0 || rest[2] != 0):
+		// Clear any entries that would otherwise be decoded.
+		for i := range rest {
+			rest[i] = 0
+		}
+	}
+	return nil
+})
+
+var encodeBGCapture = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	g, ok := r.Info.(GlucoseRecord)
+	if !ok {
+		return infoError(r)
+	}
+	n, err := glucoseToBits("glucose", g.Glucose, g.Units, 10)
+	if err != nil {
+		return err
+	}
+	data[1] = byte(n)
+	data[4] = data[4]&0x1F | byte(g.Units&0x3)<<5 | byte(n>>9)<<7
+	data[6] = data[6]&0x7F | byte(n>>8&0x1)<<7
+	return nil
+})
+
+func encodeSensorAlarm(r HistoryRecord, family Family) ([]byte, error) {
+	v, ok := r.Info.(int)
+	if !ok {
+		return nil, infoError(r)
+	}
+	if err := checkRange("sensor alarm", v, 8); err != nil {
+		return nil, err
+	}
+	data := recordBuffer(r, 8)
+	if err := putTime(data[3:8], r); err != nil {
+		return nil, err
+	}
+	data[1] = byte(v)
+	return data, nil
+}
+
+func durationEncoder(unit time.Duration) encoder {
+	return withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+		d, ok := r.Info.(Duration)
+		if !ok {
+			return infoError(r)
+		}
+		n, err := durationToUnits(r.Type().String(), d, unit, 8)
+		data[1] = byte(n)
+		return err
+	})
+}
+
+var encodeTempBasalDuration = durationEncoder(30 * time.Minute)
+
+var encodeSetAutoOff = durationEncoder(time.Hour)
+
+func encodeUnknown2E(r HistoryRecord, family Family) ([]byte, error) {
+	setup, ok := r.Info.(BolusWizardSetupRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	return withBase(107, func(data []byte, r HistoryRecord, family Family) error {
+		return putBolusWizardSetup(data[7:], setup, family)
+	})(r, family)
+}
+
+func putBolusWizardSetup(body []byte, setup BolusWizardSetupRecord, family Family) error {
+	half := len(body) / 2
+	if err := putBolusWizardConfig(body[:half], setup.Before, family); err != nil {
+		return err
+	}
+	return putBolusWizardConfig(body[half:], setup.After, family)
+}
+
+func putBolusWizardConfig(data []byte, c BolusWizardConfig, family Family) error {
+	const numEntries = 8
+	if len(c.Ratios) == 0 || len(c.Sensitivities) == 0 || len(c.Targets) == 0 {
+		return fmt.Errorf("bolus wizard setup has an empty schedule")
+	}
+	if len(c.Ratios) > numEntries || len(c.Sensitivities) > numEntries || len(c.Targets) > numEntries {
+		return fmt.Errorf("bolus wizard setup has too many schedule entries")
+	}
+	carbUnits := c.Ratios[0].Units
+	bgUnits := c.Sensitivities[0].Units
+	// Units other than exchanges and mmol/L are decoded as grams and mg/dL,
+	// so only change the stored units if necessary.
+	if (CarbUnitsType(data[0]&0x3) == Exchanges) != (carbUnits == Exchanges) {
+		data[0] = data[0]&^0x3 | byte(carbUnits&0x3)
+	}
+	if (GlucoseUnitsType(data[0]>>2&0x3) == MMolPerLiter) != (bgUnits == MMolPerLiter) {
+		data[0] = data[0]&^0xC | byte(bgUnits&0x3)<<2
+	}
+	step := carbRatioStep(family)
+	ratios := data[2 : 2+numEntries*step]
+	for i, v := range c.Ratios {
+		e := ratios[i*step:]
+		start, err := timeOfDayToHalfHours(v.Start)
+		if err != nil {
+			return err
+		}
+		e[0] = start
+		if family <= 22 {
+			n, err := ratioToBits(v.Ratio, carbUnits, family, 8)
+			if err != nil {
+				return err
+			}
+			e[1] = byte(n)
+		} else {
+			n, err := ratioToBits(v.Ratio, carbUnits, family, 15)
+			if err != nil {
+				return err
+			}
+			copy(e[1:3], marshalUint16(uint16(n)))
+		}
+	}
+	endSchedule(ratios[len(c.Ratios)*step:], 0xFF)
+	data = data[2+numEntries*step:]
+	sensitivities := data[:numEntries*2]
+	for i, v := range c.Sensitivities {
+		start, err := timeOfDayToHalfHours(v.Start)
+		if err != nil {
+			return err
+		}
+		n, err := glucoseToBits("sensitivity", v.Sensitivity, bgUnits, 9)
+		if err != nil {
+			return err
+		}
+		sensitivities[2*i] = start | byte(n>>8)<<6
+		sensitivities[2*i+1] = byte(n)
+	}
+	endSchedule(sensitivities[len(c.Sensitivities)*2:], 0x3F)
+	if family <= 22 {
+		data = data[numEntries*2:]
+	} else {
+		data = data[numEntries*2+2:]
+	}
+	step = glucoseTargetStep(family)
+	targets := data[:numEntries*step]
+	for i, v := range c.Targets {
+		e := targets[i*step:]
+		start, err := timeOfDayToHalfHours(v.Start)
+		if err != nil {
+			return err
+		}
+		low, err := glucoseToBits("target", v.Low, bgUnits, 8)
+		if err != nil {
+			return err
+		}
+		e[0] = start
+		e[1] = byte(low)
+		if family > 12 {
+			high, err := glucoseToBits("target", v.High, bgUnits, 8)
+			if err != nil {
+				return err
+			}
+			e[2] = byte(high)
+		}
+	}
+	endSchedule(targets[len(c.Targets)*step:], 0xFF)
+	return nil
+}
+
+// endSchedule clears the unused part of a schedule
+// unless it already begins with an entry starting at midnight,
+// which marks the end of the schedule.
+// The mask selects the bits of the first byte that hold the start time.
+func endSchedule(rest []byte, mask byte) {
+	if len(rest) == 0 || rest[0]&mask == 0 {
+		return
+	}
+	for i := range rest {
+		rest[i] = 0
+	}
+}
+
+func encodeBolusWizardSetup(r HistoryRecord, family Family) ([]byte, error) {
+	setup, ok := r.Info.(BolusWizardSetupRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	length := 144
+	if family <= 22 {
+		length = 124
+	}
+	return withBase(length, func(data []byte, r HistoryRecord, family Family) error {
+		n := len(data) - 1
+		if err := putBolusWizardSetup(data[7:n], setup, family); err != nil {
+			return err
+		}
+		before, err := durationToUnits("insulin action", setup.Before.InsulinAction, time.Hour, 4)
+		if err != nil {
+			return err
+		}
+		after, err := durationToUnits("insulin action", setup.After.InsulinAction, time.Hour, 4)
+		if err != nil {
+			return err
+		}
+		data[n] = byte(after)<<4 | byte(before)
+		return nil
+	})(r, family)
+}
+
+// putBolusWizardInputs encodes the glucose and carb inputs,
+// which have the same layout in all bolus wizard records.
+func putBolusWizardInputs(data []byte, w BolusWizardRecord) error {
+	bg, err := glucoseToBits("glucose input", w.GlucoseInput, w.GlucoseUnits, 10)
+	if err != nil {
+		return err
+	}
+	if err := checkRange("carb input", int(w.CarbInput), 10); err != nil {
+		return err
+	}
+	if w.CarbUnits != Grams && w.CarbUnits != Exchanges {
+		return fmt.Errorf("unknown carb unit %d", w.CarbUnits)
+	}
+	body := data[7:]
+	data[1] = byte(bg)
+	body[0] = byte(w.CarbInput)
+	body[1] = byte(w.GlucoseUnits)<<6 | byte(w.CarbUnits)<<4 | byte(w.CarbInput>>8)<<2 | byte(bg>>8)
+	return nil
+}
+
+// putOneByteWizard encodes the fields of x22 and earlier bolus wizard records.
+func putOneByteWizard(data []byte, w BolusWizardRecord, family Family) error {
+	if err := putBolusWizardInputs(data, w); err != nil {
+		return err
+	}
+	body := data[7:]
+	ratio, err := ratioToBits(w.CarbRatio, w.CarbUnits, family, 8)
+	if err != nil {
+		return err
+	}
+	sensitivity, err := glucoseToBits("sensitivity", w.Sensitivity, w.GlucoseUnits, 8)
+	if err != nil {
+		return err
+	}
+	low, err := glucoseToBits("target", w.TargetLow, w.GlucoseUnits, 8)
+	if err != nil {
+		return err
+	}
+	correction, err := insulinToStrokes("correction", w.Correction, family, 9)
+	if err != nil {
+		return err
+	}
+	values := []struct {
+		kind string
+		v    Insulin
+		pos  int
+	}{
+		{"food", w.Food, 6},
+		{"unabsorbed insulin", w.Unabsorbed, 9},
+		{"bolus", w.Bolus, 11},
+	}
+	for _, x := range values {
+		n, err := insulinToStrokes(x.kind, x.v, family, 8)
+		if err != nil {
+			return err
+		}
+		body[x.pos] = byte(n)
+	}
+	body[2] = byte(ratio)
+	body[3] = byte(sensitivity)
+	body[4] = byte(low)
+	// The correction is the sum of byte 7 and the low nibble of byte 5.
+	extra := int(body[5] & 0xF)
+	if correction < extra || correction-extra > 0xFF {
+		body[5] &^= 0xF
+		extra = 0
+	}
+	if correction-extra > 0xFF {
+		return fmt.Errorf("correction (%d) is out of range", w.Correction)
+	}
+	body[7] = byte(correction - extra)
+	return nil
+}
+
+func encodeBolusWizard512(r HistoryRecord, family Family) ([]byte, error) {
+	w, ok := r.Info.(BolusWizardRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	if w.TargetHigh != w.TargetLow {
+		return nil, fmt.Errorf("x12 pumps do not record a target range")
+	}
+	return withBase(19, func(data []byte, r HistoryRecord, family Family) error {
+		return putOneByteWizard(data, w, 12)
+	})(r, family)
+}
+
+func encodeBolusWizard(r HistoryRecord, family Family) ([]byte, error) {
+	w, ok := r.Info.(BolusWizardRecord)
+	if !ok {
+		return nil, infoError(r)
+	}
+	if family <= 22 {
+		return withBase(20, func(data []byte, r HistoryRecord, family Family) error {
+			if err := putOneByteWizard(data, w, family); err != nil {
+				return err
+			}
+			high, err := glucoseToBits("target", w.TargetHigh, w.GlucoseUnits, 8)
+			data[7+12] = byte(high)
+			return err
+		})(r, family)
+	}
+	return withBase(22, func(data []byte, r HistoryRecord, family Family) error {
+		if err := putBolusWizardInputs(data, w); err != nil {
+			return err
+		}
+		body := data[7:]
+		ratio, err := ratioToBits(w.CarbRatio, w.CarbUnits, family, 12)
+		if err != nil {
+			return err
+		}
+		glucose := []struct {
+			kind string
+			g    Glucose
+			pos  int
+		}{
+			{"sensitivity", w.Sensitivity, 4},
+			{"target", w.TargetLow, 5},
+			{"target", w.TargetHigh, 14},
+		}
+		for _, x := range glucose {
+			n, err := glucoseToBits(x.kind, x.g, w.GlucoseUnits, 8)
+			if err != nil {
+				return err
+			}
+			body[x.pos] = byte(n)
+		}
+		correction, err := insulinToStrokes("correction", w.Correction, family, 11)
+		if err != nil {
+			return err
+		}
+		body[2] = body[2]&0xF0 | byte(ratio>>8)
+		body[3] = byte(ratio)
+		body[6] = byte(correction)
+		body[9] = body[9]&^0x38 | byte(correction>>8)<<3
+		if err := putTwoByteInsulin(body[7:9], "food", w.Food, family); err != nil {
+			return err
+		}
+		if err := putTwoByteInsulin(body[10:12], "unabsorbed insulin", w.Unabsorbed, family); err != nil {
+			return err
+		}
+		return putTwoByteInsulin(body[12:14], "bolus", w.Bolus, family)
+	})(r, family)
+}
+
+func encodeUnabsorbedInsulin(r HistoryRecord, family Family) ([]byte, error) {
+	v, ok := r.Info.(UnabsorbedBolusHistory)
+	if !ok {
+		return nil, infoError(r)
+	}
+	n := 3 * len(v)
+	if err := checkRange("unabsorbed insulin record length", n+2, 8); err != nil {
+		return nil, err
+	}
+	data := recordBuffer(r, n+2)
+	data[1] = byte(n + 2)
+	for i, b := range v {
+		e := data[2+3*i:]
+		amount, err := insulinToStrokes("unabsorbed bolus", b.Bolus, 23, 8)
+		if err != nil {
+			return nil, err
+		}
+		age, err := durationToUnits("unabsorbed bolus age", b.Age, time.Minute, 10)
+		if err != nil {
+			return nil, err
+		}
+		e[0] = byte(amount)
+		e[1] = byte(age)
+		e[2] = e[2]&^0x30 | byte(age>>8)<<4
+	}
+	return data, nil
+}
+
+var encodeTempBasalRate = withBase(8, func(data []byte, r HistoryRecord, family Family) error {
+	tb, ok := r.Info.(TempBasalRecord)
+	if !ok {
+		return infoError(r)
+	}
+	var n int
+	var err error
+	switch v := tb.Value.(type) {
+	case Insulin:
+		if tb.Type != Absolute {
+			return fmt.Errorf("temp basal rate has type %v but value %v", tb.Type, v)
+		}
+		n, err = insulinToStrokes("temp basal rate", v, 23, 11)
+	case int:
+		if tb.Type != Percent {
+			return fmt.Errorf("temp basal rate has type %v but value %d", tb.Type, v)
+		}
+		n, err = v, checkRange("temp basal percent", v, 8)
+	default:
+		return infoError(r)
+	}
+	if err != nil {
+		return err
+	}
+	data[1] = byte(n)
+	data[7] = byte(tb.Type)<<3 | byte(n>>8)
+	return nil
+})
+
+var encodeLowReservoir = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(Insulin)
+	if !ok {
+		return infoError(r)
+	}
+	n, err := insulinToStrokes("reservoir level", v, 22, 8)
+	data[1] = byte(n)
+	return err
+})
+
+var encodeBGReceived = withBase(10, func(data []byte, r HistoryRecord, family Family) error {
+	g, ok := r.Info.(GlucoseRecord)
+	if !ok {
+		return infoError(r)
+	}
+	if g.Units != MgPerDeciLiter {
+		return fmt.Errorf("meter glucose must be in mg/dL")
+	}
+	n, err := glucoseToBits("glucose", g.Glucose, g.Units, 11)
+	if err != nil {
+		return err
+	}
+	id, err := hex.DecodeString(g.MeterID)
+	if err != nil || len(id) != 3 {
+		return fmt.Errorf("invalid meter ID %q", g.MeterID)
+	}
+	data[1] = byte(n >> 3)
+	data[4] = data[4]&0x1F | byte(n&0x7)<<5
+	copy(data[7:10], id)
+	return nil
+})
+
+var encodeMealMarker = withBase(9, func(data []byte, r HistoryRecord, family Family) error {
+	c, ok := r.Info.(CarbRecord)
+	if !ok {
+		return infoError(r)
+	}
+	if err := checkRange("carbs", int(c.Carbs), 16); err != nil {
+		return err
+	}
+	data[1] = byte(c.Carbs >> 8)
+	data[7] = byte(c.Carbs)
+	data[8] = data[8]&^0x3 | byte(c.Units&0x3)
+	return nil
+})
+
+var encodeInsulinMarker = withBase(8, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(Insulin)
+	if !ok {
+		return infoError(r)
+	}
+	n, err := insulinToStrokes("insulin marker", v, 22, 10)
+	if err != nil {
+		return err
+	}
+	data[1] = byte(n)
+	data[4] = data[4]&^0x60 | byte(n>>8)<<5
+	return nil
+})
+
+func encodeSensorSetup(r HistoryRecord, family Family) ([]byte, error) {
+	if family >= 51 {
+		return encodeBaseN(41)(r, family)
+	}
+	return encodeBaseN(37)(r, family)
+}
+
+var encodeChangeSensorAlarm = withBase(8, func(data []byte, r HistoryRecord, family Family) error {
+	s, ok := r.Info.(SensorAlarmSilenceRecord)
+	if !ok {
+		return infoError(r)
+	}
+	if err := checkRange("alarm silence mode", s.Mode, 8); err != nil {
+		return err
+	}
+	n, err := durationToUnits("alarm silence duration", s.Duration, time.Minute, 8)
+	data[1] = byte(s.Mode)
+	data[7] = byte(n)
+	return err
+})
+
+// putSensorSchedule encodes a schedule of 3-byte entries,
+// as used by Sensor55 records.
+func putSensorSchedule(data []byte, n int, entry func(i int, e []byte) error) error {
+	if n == 0 {
+		return fmt.Errorf("empty sensor alert schedule")
+	}
+	if 3*n > len(data) {
+		return fmt.Errorf("sensor alert schedule has too many entries (%d)", n)
+	}
+	for i := 0; i < n; i++ {
+		if err := entry(i, data[3*i:3*i+3]); err != nil {
+			return err
+		}
+	}
+	endSchedule(data[3*n:], 0xFF)
+	return nil
+}
+
+func putSensorPredictiveAlerts(data []byte, sched []SensorPredictiveAlert) error {
+	return putSensorSchedule(data, len(sched), func(i int, e []byte) error {
+		v := sched[i]
+		start, err := timeOfDayToHalfHours(v.Start)
+		if err != nil {
+			return err
+		}
+		high, err := durationToUnits("high predictive alert", v.High, time.Minute, 8)
+		if err != nil {
+			return err
+		}
+		low, err := durationToUnits("low predictive alert", v.Low, time.Minute, 8)
+		if err != nil {
+			return err
+		}
+		e[0], e[1], e[2] = start, byte(high), byte(low)
+		return nil
+	})
+}
+
+var encodeSensor55 = withBase(55, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(SensorPredictiveAlertsRecord)
+	if !ok {
+		return infoError(r)
+	}
+	if err := putSensorPredictiveAlerts(data[7:31], v.Before); err != nil {
+		return err
+	}
+	return putSensorPredictiveAlerts(data[31:55], v.After)
+})
+
+var encodeChangeTempBasalType = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(TempBasalType)
+	if !ok {
+		return infoError(r)
+	}
+	data[1] = byte(v)
+	return nil
+})
+
+var encodeChangeReservoirWarning = withBase(7, func(data []byte, r HistoryRecord, family Family) error {
+	var n int
+	var err error
+	flag := byte(0)
+	switch v := r.Info.(type) {
+	case Insulin:
+		if v%1000 != 0 {
+			return fmt.Errorf("reservoir warning (%d) is not a whole number of units", v)
+		}
+		n = int(v / 1000)
+		err = checkRange("reservoir warning", n, 6)
+	case Duration:
+		n, err = durationToUnits("reservoir warning", v, 30*time.Minute, 6)
+		flag = 1
+	default:
+		return infoError(r)
+	}
+	data[1] = data[1]&0x2 | byte(n)<<2 | flag
+	return err
+})
+
+func encodeDailyTotalSummary(length int) encoder {
+	return func(r HistoryRecord, family Family) ([]byte, error) {
+		v, ok := r.Info.(DailyTotalRecord)
+		if !ok {
+			return nil, infoError(r)
+		}
+		data := recordBuffer(r, length)
+		if err := putDate(data[1:3], r); err != nil {
+			return nil, err
+		}
+		totals := []struct {
+			kind string
+			v    Insulin
+			pos  int
+		}{
+			{"total insulin", v.Total, 11},
+			{"basal insulin", v.Basal, 13},
+			{"bolus insulin", v.Bolus, 16},
+		}
+		for _, x := range totals {
+			if err := putTwoByteInsulin(data[x.pos:x.pos+2], x.kind, x.v, 23); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkRange("basal percent", v.BasalPercent, 8); err != nil {
+			return nil, err
+		}
+		if err := checkRange("bolus percent", v.BolusPercent, 8); err != nil {
+			return nil, err
+		}
+		data[15] = byte(v.BasalPercent)
+		data[18] = byte(v.BolusPercent)
+		if length != 52 {
+			if v.Carbs != 0 || v.BGCount != 0 || v.BGAverage != 0 {
+				return nil, fmt.Errorf("%v records do not include carbs or BG", r.Type())
+			}
+			return data, nil
+		}
+		if err := checkRange("BG average", int(v.BGAverage), 10); err != nil {
+			return nil, err
+		}
+		if err := checkRange("BG count", v.BGCount, 8); err != nil {
+			return nil, err
+		}
+		if err := checkRange("carbs", int(v.Carbs), 15); err != nil {
+			return nil, err
+		}
+		data[4] = data[4]&^0x3 | byte(v.BGAverage>>8)
+		data[5] = byte(v.BGAverage)
+		data[8] = byte(v.BGCount)
+		copy(data[19:21], marshalUint16(uint16(v.Carbs)))
+		return data, nil
+	}
+}
+
+var encodeBasalProfileStart = withBase(10, func(data []byte, r HistoryRecord, family Family) error {
+	v, ok := r.Info.(BasalProfileStartRecord)
+	if !ok {
+		return infoError(r)
+	}
+	if err := checkRange("profile index", v.ProfileIndex, 8); err != nil {
+		return err
+	}
+	data[1] = byte(v.ProfileIndex)
+	return putBasalRate(data[7:10], v.BasalRate)
+})
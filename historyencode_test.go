@@ -0,0 +1,161 @@
+package medtronic
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ecc1/medtronic/packet"
+)
+
+var encodeTestCases = []testCase{
+	{"model", 512, 1},
+	{"model", 512, 2},
+	{"model", 515, 0},
+	{"model", 522, 0},
+	{"model", 523, 1},
+	{"model", 523, 2},
+	{"ps2", 522, 1},
+	{"ps2", 522, 2},
+	{"ps2", 523, 1},
+	{"ps2", 523, 2},
+	{"ps2", 523, 3},
+	{"ps2", 523, 4},
+	{"ps2", 523, 5},
+	{"ps2", 523, 6},
+	{"ps2", 551, 1},
+	{"ps2", 551, 2},
+	{"ps2", 551, 3},
+	{"ps2", 551, 4},
+	{"ps2", 554, 1},
+	{"ps2", 554, 2},
+	{"ps2", 554, 3},
+	{"ps2", 554, 4},
+	{"ps2", 554, 5},
+}
+
+func TestEncodeHistoryRecord(t *testing.T) {
+	for _, c := range encodeTestCases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			family := testPumpFamily(c)
+			records, err := decodeFromData(testFile+".json", family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range records {
+				// Re-encoding a decoded record reproduces it exactly.
+				data, err := EncodeHistoryRecord(r, family)
+				if err != nil {
+					t.Errorf("EncodeHistoryRecord(%v) returned %v", r, err)
+					continue
+				}
+				if !bytes.Equal(data, r.Data) {
+					t.Errorf("EncodeHistoryRecord(%v) = % X, want % X", r, data, r.Data)
+				}
+				// Encoding just the type, time, and info round-trips them.
+				n, err := NewHistoryRecord(r.Type(), r.Time, r.Info, family)
+				if err != nil {
+					t.Errorf("NewHistoryRecord(%v) returned %v", r, err)
+					continue
+				}
+				if !n.Time.Equal(r.Time) || !reflect.DeepEqual(n.Info, r.Info) {
+					t.Errorf("NewHistoryRecord(%v) = %v", r, n)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeHistoryPages(t *testing.T) {
+	for _, c := range encodeTestCases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			family := testPumpFamily(c)
+			records, err := decodeFromData(testFile+".json", family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages, err := EncodeHistoryPages(records, family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded History
+			for i, page := range pages {
+				if len(page) != historyPageDataSize+2 {
+					t.Fatalf("page %d has length %d", i, len(page))
+				}
+				data := page[:historyPageDataSize]
+				if twoByteUint(page[historyPageDataSize:]) != packet.CRC16(data) {
+					t.Errorf("page %d has incorrect CRC", i)
+				}
+				h, err := DecodeHistory(data, family)
+				if err != nil {
+					t.Fatalf("DecodeHistory(page %d) returned %v", i, err)
+				}
+				decoded = append(decoded, h...)
+			}
+			if !reflect.DeepEqual(decoded, records) {
+				t.Errorf("decoded pages do not match original records")
+			}
+		})
+	}
+}
+
+func TestEncodeHistoryPage(t *testing.T) {
+	const family = 23
+	record := func(typ HistoryRecordType, ts string, info interface{}) HistoryRecord {
+		r, err := NewHistoryRecord(typ, parseTime(ts), info, family)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	// Records in reverse chronological order.
+	records := History{
+		record(Bolus, "2020-03-10T12:01:00", BolusRecord{Programmed: 3500, Amount: 3500}),
+		record(BolusWizard, "2020-03-10T12:00:30", BolusWizardRecord{
+			GlucoseInput: 150,
+			CarbInput:    45,
+			GlucoseUnits: MgPerDeciLiter,
+			CarbUnits:    Grams,
+			TargetLow:    100,
+			TargetHigh:   120,
+			Sensitivity:  50,
+			CarbRatio:    150,
+			Correction:   600,
+			Food:         3000,
+			Bolus:        3500,
+		}),
+		record(TempBasalRate, "2020-03-10T08:00:00", TempBasalRecord{Type: Absolute, Value: Insulin(1250)}),
+		record(TempBasalDuration, "2020-03-10T08:00:00", Duration(90*time.Minute)),
+		record(BasalProfileStart, "2020-03-10T00:00:00", BasalProfileStartRecord{
+			ProfileIndex: 0,
+			BasalRate:    BasalRate{Start: 0, Rate: 800},
+		}),
+	}
+	page, err := EncodeHistoryPage(records, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := page[:historyPageDataSize]
+	if twoByteUint(page[historyPageDataSize:]) != packet.CRC16(data) {
+		t.Errorf("incorrect CRC")
+	}
+	decoded, err := DecodeHistory(data, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, records) {
+		t.Errorf("DecodeHistory(EncodeHistoryPage()) = %v, want %v", decoded, records)
+	}
+
+	var tooMany History
+	for len(tooMany) < 100 {
+		tooMany = append(tooMany, records...)
+	}
+	if _, err := EncodeHistoryPage(tooMany, family); err == nil {
+		t.Errorf("EncodeHistoryPage(%d records) succeeded", len(tooMany))
+	}
+}
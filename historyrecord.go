@@ -203,6 +203,8 @@ type (
 		Data []byte
 		Time time.Time
 		Info interface{} `json:",omitempty"`
+		// Original pump time, if Time has been normalized.
+		PumpTime time.Time
 	}
 
 	History []HistoryRecord
@@ -267,6 +269,36 @@ type (
 		After  BolusWizardConfig
 	}
 
+	DailyTotalRecord struct {
+		Total        Insulin
+		Basal        Insulin
+		BasalPercent int
+		Bolus        Insulin
+		BolusPercent int
+		// Only recorded by x23 and later pumps.
+		Carbs     Carbs   `json:",omitempty"`
+		BGCount   int     `json:",omitempty"`
+		BGAverage Glucose `json:",omitempty"` // mg/dL
+	}
+
+	// How far ahead high and low sensor glucose are predicted
+	// for predictive alerts, starting at a given time of day.
+	SensorPredictiveAlert struct {
+		Start TimeOfDay
+		High  Duration
+		Low   Duration
+	}
+
+	SensorPredictiveAlertsRecord struct {
+		Before []SensorPredictiveAlert
+		After  []SensorPredictiveAlert
+	}
+
+	SensorAlarmSilenceRecord struct {
+		Mode     int
+		Duration Duration
+	}
+
 	UnabsorbedBolus struct {
 		Bolus Insulin
 		Age   Duration
@@ -490,7 +522,20 @@ var decodeMaxBasal = decodeInsulin
 
 var decodeEnableBolusWizard = decodeEnable
 
-var decodeUnknown2E = decodeBaseN(107)
+// Unknown2E records are written by x12 pumps when the bolus wizard
+// is set up. They have the same layout as BolusWizardSetup records
+// but do not include the insulin action time.
+func decodeUnknown2E(data []byte, family Family) HistoryRecord {
+	r := decodeBase(data, family)
+	r.Data = data[:107]
+	body := r.Data[7:]
+	half := len(body) / 2
+	r.Info = BolusWizardSetupRecord{
+		Before: decodeBolusWizardConfig(body[:half], family),
+		After:  decodeBolusWizardConfig(body[half:], family),
+	}
+	return r
+}
 
 func decodeBolusWizard512(data []byte, family Family) HistoryRecord {
 	r := decodeBase(data, family)
@@ -607,11 +652,56 @@ var decodeSensor51 = decodeBase
 
 var decodeSensor52 = decodeBase
 
-var decodeChangeSensorAlarm = decodeBaseN(8)
-
 var decodeSensor54 = decodeBaseN(64)
 
-var decodeSensor55 = decodeBaseN(55)
+// The alarm silence mode is recorded as a raw value;
+// the duration is in minutes.
+func decodeChangeSensorAlarm(data []byte, family Family) HistoryRecord {
+	r := decodeBase(data, family)
+	r.Data = data[:8]
+	r.Info = SensorAlarmSilenceRecord{
+		Mode:     int(data[1]),
+		Duration: minutesToDuration(data[7]),
+	}
+	return r
+}
+
+// sensorScheduleEntries splits a sensor alert schedule into 3-byte entries,
+// each consisting of a start time in half-hours followed by two values.
+func sensorScheduleEntries(data []byte) [][]byte {
+	var entries [][]byte
+	for i := 0; i <= len(data)-3; i += 3 {
+		if data[i] == 0 && len(entries) != 0 {
+			break
+		}
+		entries = append(entries, data[i:i+3])
+	}
+	return entries
+}
+
+func decodeSensorPredictiveAlerts(data []byte) []SensorPredictiveAlert {
+	var sched []SensorPredictiveAlert
+	for _, e := range sensorScheduleEntries(data) {
+		sched = append(sched, SensorPredictiveAlert{
+			Start: halfHoursToTimeOfDay(e[0]),
+			High:  minutesToDuration(e[1]),
+			Low:   minutesToDuration(e[2]),
+		})
+	}
+	return sched
+}
+
+// Sensor55 records hold the schedules of predictive alert
+// time sensitivities before and after a change.
+func decodeSensor55(data []byte, family Family) HistoryRecord {
+	r := decodeBase(data, family)
+	r.Data = data[:55]
+	r.Info = SensorPredictiveAlertsRecord{
+		Before: decodeSensorPredictiveAlerts(data[7:31]),
+		After:  decodeSensorPredictiveAlerts(data[31:55]),
+	}
+	return r
+}
 
 var decodeChangeSensorAlert = decodeBaseN(12)
 
@@ -639,7 +729,7 @@ func decodeBolusWizardConfig(data []byte, family Family) BolusWizardConfig {
 	} else {
 		data = data[numEntries*2+2:]
 	}
-	r.Targets = decodeGlucoseTargetSchedule(data[:numEntries*3], bgUnits, family)
+	r.Targets = decodeGlucoseTargetSchedule(data[:numEntries*glucoseTargetStep(family)], bgUnits, family)
 	return r
 }
 
@@ -712,11 +802,12 @@ func decodeUnabsorbedInsulin(data []byte, family Family) HistoryRecord {
 	var unabsorbed UnabsorbedBolusHistory
 	for i := 0; i < n; i += 3 {
 		amount := byteToInsulin(body[i], 23)
+		// The high bits of the age are in the curve byte.
 		curve := body[i+2]
-		age := minutesToDuration(body[i+1] + (curve&0x30)<<4)
+		age := int(curve&0x30)<<4 | int(body[i+1])
 		unabsorbed = append(unabsorbed, UnabsorbedBolus{
 			Bolus: amount,
-			Age:   age,
+			Age:   Duration(time.Duration(age) * time.Minute),
 		})
 	}
 	return HistoryRecord{
@@ -766,11 +857,34 @@ var decodeBolusReminder = decodeBaseN(9)
 
 var decodeDeleteAlarmClockTime = decodeBase
 
-var decodeDailyTotal515 = decodeDailyTotalN(38)
+var decodeDailyTotal515 = decodeDailyTotalSummary(38)
 
-var decodeDailyTotal522 = decodeDailyTotalN(44)
+var decodeDailyTotal522 = decodeDailyTotalSummary(44)
 
-var decodeDailyTotal523 = decodeDailyTotalN(52)
+var decodeDailyTotal523 = decodeDailyTotalSummary(52)
+
+// decodeDailyTotalSummary returns a decoder for DailyTotal515/522/523 records.
+// The insulin totals are common to all three formats;
+// the remaining fields are only decoded for the DailyTotal523 format.
+func decodeDailyTotalSummary(length int) decoder {
+	return func(data []byte, family Family) HistoryRecord {
+		r := decodeDailyTotalN(length)(data, family)
+		info := DailyTotalRecord{
+			Total:        twoByteInsulin(data[11:13], 23),
+			Basal:        twoByteInsulin(data[13:15], 23),
+			BasalPercent: int(data[15]),
+			Bolus:        twoByteInsulin(data[16:18], 23),
+			BolusPercent: int(data[18]),
+		}
+		if length == 52 {
+			info.BGAverage = Glucose(int(data[4]&0x3)<<8 | int(data[5]))
+			info.BGCount = int(data[8])
+			info.Carbs = Carbs(twoByteInt(data[19:21]))
+		}
+		r.Info = info
+		return r
+	}
+}
 
 var decodeChangeCarbUnits = decodeValue
 
@@ -1,12 +1,14 @@
 package medtronic
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"reflect"
 	"testing"
 )
 
@@ -166,6 +168,61 @@ func checkHistory(t *testing.T, decoded History, jsonFile string) {
 	}
 }
 
+func TestUnmarshalHistoryRecord(t *testing.T) {
+	cases := []testCase{
+		{"model", 512, 1},
+		{"model", 515, 0},
+		{"model", 523, 1},
+		{"ps2", 522, 2},
+		{"ps2", 523, 6},
+		{"ps2", 551, 1},
+		{"ps2", 554, 1},
+		{"ps2", 554, 5},
+		{"records", 522, 0},
+		{"records", 523, 0},
+		{"records", 554, 0},
+	}
+	for _, c := range cases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			jsonFile := testFile + ".json"
+			records, err := decodeFromData(jsonFile, testPumpFamily(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(jsonFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var unmarshaled History
+			err = json.NewDecoder(f).Decode(&unmarshaled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unmarshaled) != len(records) {
+				t.Fatalf("unmarshaled %d records, want %d", len(unmarshaled), len(records))
+			}
+			for i, r := range unmarshaled {
+				d := records[i]
+				if !bytes.Equal(r.Data, d.Data) || !r.Time.Equal(d.Time) || !reflect.DeepEqual(r.Info, d.Info) {
+					t.Errorf("unmarshaled %v, want %v", r, d)
+				}
+			}
+		})
+	}
+	// Records without data are identified by their Type field.
+	var r HistoryRecord
+	err := json.Unmarshal([]byte(`{"Type":"TempBasalRate","Info":{"Type":"Percent","Value":50}}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TempBasalRecord{Type: Percent, Value: 50}
+	if r.Type() != TempBasalRate || !reflect.DeepEqual(r.Info, want) {
+		t.Errorf("unmarshaled %v, want %v record with info %+v", r, TempBasalRate, want)
+	}
+}
+
 func (r HistoryRecord) String() string {
 	b, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
@@ -205,38 +262,40 @@ func TestTreatments(t *testing.T) {
 
 const jqFilter = "cmd/pumphistory/openaps.jq"
 
+// Test cases with .openaps files.
+var openAPSTestCases = []testCase{
+	{"model", 512, 1},
+	{"model", 512, 2},
+	{"model", 515, 0},
+	{"model", 522, 0},
+	{"model", 523, 1},
+	{"model", 523, 2},
+	{"ps2", 522, 1},
+	{"ps2", 522, 2},
+	{"ps2", 523, 1},
+	{"ps2", 523, 2},
+	{"ps2", 523, 3},
+	{"ps2", 523, 4},
+	{"ps2", 523, 5},
+	{"ps2", 523, 6},
+	{"ps2", 551, 1},
+	{"ps2", 551, 2},
+	{"ps2", 551, 3},
+	{"ps2", 551, 4},
+	{"ps2", 554, 1},
+	{"ps2", 554, 2},
+	{"ps2", 554, 3},
+	{"ps2", 554, 4},
+	{"ps2", 554, 5},
+	{"pump-records", 522, 0},
+	{"pump-records", 523, 0},
+	{"records", 522, 0},
+	{"records", 523, 0},
+	{"records", 554, 0},
+}
+
 func TestJQFilter(t *testing.T) {
-	cases := []testCase{
-		{"model", 512, 1},
-		{"model", 512, 2},
-		{"model", 515, 0},
-		{"model", 522, 0},
-		{"model", 523, 1},
-		{"model", 523, 2},
-		{"ps2", 522, 1},
-		{"ps2", 522, 2},
-		{"ps2", 523, 1},
-		{"ps2", 523, 2},
-		{"ps2", 523, 3},
-		{"ps2", 523, 4},
-		{"ps2", 523, 5},
-		{"ps2", 523, 6},
-		{"ps2", 551, 1},
-		{"ps2", 551, 2},
-		{"ps2", 551, 3},
-		{"ps2", 551, 4},
-		{"ps2", 554, 1},
-		{"ps2", 554, 2},
-		{"ps2", 554, 3},
-		{"ps2", 554, 4},
-		{"ps2", 554, 5},
-		{"pump-records", 522, 0},
-		{"pump-records", 523, 0},
-		{"records", 522, 0},
-		{"records", 523, 0},
-		{"records", 554, 0},
-	}
-	for _, c := range cases {
+	for _, c := range openAPSTestCases {
 		testFile := testFileName(c)
 		t.Run(testFile, func(t *testing.T) {
 			jsonFile := testFile + ".json"
@@ -0,0 +1,201 @@
+package medtronic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// HistoryStore is a local copy of pump history records,
+// kept in chronological order and backed by an append-only file.
+// Each line of the file holds the raw data of one record
+// along with the pump family needed to decode it and its record ID,
+// so the store does not depend on the JSON representation of decoded records.
+type HistoryStore struct {
+	path    string
+	records History
+	ids     []RecordID
+	seen    map[RecordID]bool
+}
+
+// storedRecord is the representation of a record in the store's file.
+// The ID is absent in files written before record IDs were introduced.
+type storedRecord struct {
+	Family Family
+	Data   []byte
+	ID     RecordID `json:",omitempty"`
+}
+
+// OpenHistoryStore opens the history store in the given file,
+// creating it if it does not exist.
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	s := &HistoryStore{path: path, seen: make(map[RecordID]bool)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records History
+	var ids []RecordID
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var sr storedRecord
+		err = json.Unmarshal(scanner.Bytes(), &sr)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		r, err := DecodeHistoryRecord(sr.Data, sr.Family)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		records = append(records, r)
+		ids = append(ids, sr.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	computed := chronologicalIDs(records)
+	for i, r := range records {
+		if ids[i] == "" {
+			ids[i] = computed[i]
+		}
+		s.remember(r, ids[i])
+	}
+	return s, nil
+}
+
+func (s *HistoryStore) remember(r HistoryRecord, id RecordID) {
+	s.records = append(s.records, r)
+	s.ids = append(s.ids, id)
+	s.seen[id] = true
+}
+
+// Records returns all the records in the store, in chronological order.
+func (s *HistoryStore) Records() History {
+	return s.records
+}
+
+// Last returns the most recently synced record,
+// or nil if the store is empty.
+func (s *HistoryStore) Last() *HistoryRecord {
+	n := len(s.records)
+	if n == 0 {
+		return nil
+	}
+	return &s.records[n-1]
+}
+
+// LastID returns the ID of the most recently synced record,
+// or the empty string if the store is empty.
+func (s *HistoryStore) LastID() RecordID {
+	n := len(s.ids)
+	if n == 0 {
+		return ""
+	}
+	return s.ids[n-1]
+}
+
+// Add appends records in chronological order to the store,
+// skipping any that it already holds.
+// Records are identified by their record IDs, so the records
+// should begin early enough for those to be computed reliably.
+// It returns the records that were added.
+func (s *HistoryStore) Add(records History, family Family) (History, error) {
+	return s.add(records, chronologicalIDs(records), family)
+}
+
+// add implements Add using the given record IDs.
+func (s *HistoryStore) add(records History, ids []RecordID, family Family) (History, error) {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var added History
+	for i, r := range records {
+		id := ids[i]
+		if s.seen[id] {
+			continue
+		}
+		err = enc.Encode(storedRecord{Family: family, Data: r.Data, ID: id})
+		if err != nil {
+			break
+		}
+		s.remember(r, id)
+		added = append(added, r)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return added, err
+}
+
+// Query returns the records in the store with timestamps
+// in the interval [from, to), in chronological order.
+// A zero value for to means there is no upper bound.
+// If any record types are given, only records of those types are returned.
+func (s *HistoryStore) Query(from, to time.Time, types ...HistoryRecordType) History {
+	var results History
+	for _, r := range s.records {
+		if r.Time.Before(from) || (!to.IsZero() && !r.Time.Before(to)) {
+			continue
+		}
+		if len(types) != 0 && !hasType(r, types) {
+			continue
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func hasType(r HistoryRecord, types []HistoryRecordType) bool {
+	for _, t := range types {
+		if r.Type() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncHistory retrieves the history records that are newer than
+// the store's most recently synced record and adds them to the store.
+// If that record is no longer in the pump's history (or the store is empty),
+// the entire pump history is retrieved and duplicates are skipped.
+// Records are identified by their record IDs, so identical records
+// (such as two equal boluses in the same minute) are both kept.
+// Nothing is added if an error occurs, so that the store never has gaps.
+// It returns the records that were added, in chronological order.
+func (pump *Pump) SyncHistory(s *HistoryStore) History {
+	var results History
+	var ids []RecordID
+	if last := s.LastID(); last != "" {
+		results, ids, _ = pump.HistoryFromID(last)
+	} else {
+		results = pump.findHistory(func(HistoryRecord) bool { return false })
+		ids = RecordIDs(results)
+	}
+	family := pump.Family()
+	if pump.Error() != nil {
+		return nil
+	}
+	ReverseHistory(results)
+	reverseIDs(ids)
+	added, err := s.add(results, ids, family)
+	pump.SetError(err)
+	return added
+}
+
+func reverseIDs(ids []RecordID) {
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+}
@@ -0,0 +1,116 @@
+package medtronic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryStore(t *testing.T) {
+	records := setupPumpHistory()
+	ReverseHistory(records)
+	dir, err := ioutil.TempDir("", "historystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.json")
+	s, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Last() != nil {
+		t.Errorf("Last() == %v for empty store, want nil", s.Last())
+	}
+	n := len(records) / 2
+	added, err := s.Add(records[:n], 23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != n {
+		t.Errorf("Add added %d records, want %d", len(added), n)
+	}
+	// Reopen the store and add overlapping records.
+	s, err = OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Records()) != n {
+		t.Errorf("reopened store has %d records, want %d", len(s.Records()), n)
+	}
+	added, err = s.Add(records, 23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, records[n:]) {
+		t.Errorf("Add added %d records, want %d", len(added), len(records)-n)
+	}
+	s, err = OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Records(), records) {
+		t.Errorf("reopened store has %d records, want %d", len(s.Records()), len(records))
+	}
+	last := s.Last()
+	if last == nil || !reflect.DeepEqual(*last, records[len(records)-1]) {
+		t.Errorf("Last() == %v, want %v", last, records[len(records)-1])
+	}
+}
+
+func TestHistoryStoreQuery(t *testing.T) {
+	records := setupPumpHistory()
+	ReverseHistory(records)
+	s := &HistoryStore{seen: make(map[RecordID]bool)}
+	ids := chronologicalIDs(records)
+	for i, r := range records {
+		s.remember(r, ids[i])
+	}
+	cases := []struct {
+		from  string
+		to    string
+		types []HistoryRecordType
+		count int
+	}{
+		{"2000-01-01T00:00", "", nil, len(records) - countZeroTime(records)},
+		{"2020-02-25T09:00", "", nil, 8},
+		{"2020-02-24T23:00", "2020-02-25T09:00", nil, 41},
+		{"2020-02-24T01:00", "", []HistoryRecordType{Bolus}, countType(records, Bolus)},
+	}
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			from := parseTime(c.from)
+			var to time.Time
+			if c.to != "" {
+				to = parseTime(c.to)
+			}
+			results := s.Query(from, to, c.types...)
+			if len(results) != c.count {
+				t.Errorf("Query(%s, %s, %v) returned %d records, want %d", c.from, c.to, c.types, len(results), c.count)
+			}
+		})
+	}
+}
+
+func countZeroTime(records History) int {
+	n := 0
+	for _, r := range records {
+		if r.Time.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func countType(records History, t HistoryRecordType) int {
+	n := 0
+	for _, r := range records {
+		if r.Type() == t {
+			n++
+		}
+	}
+	return n
+}
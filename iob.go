@@ -0,0 +1,311 @@
+package medtronic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// InsulinCurve represents the shape of an insulin activity curve.
+type InsulinCurve int
+
+const (
+	// BilinearCurve models insulin activity as rising linearly to a peak
+	// and then falling linearly to zero at the end of the insulin action time.
+	BilinearCurve InsulinCurve = iota
+	// ExponentialCurve models insulin activity with the exponential curve
+	// used by oref0 and Loop.
+	ExponentialCurve
+)
+
+func (c InsulinCurve) String() string {
+	switch c {
+	case BilinearCurve:
+		return "bilinear"
+	case ExponentialCurve:
+		return "exponential"
+	default:
+		return fmt.Sprintf("InsulinCurve(%d)", int(c))
+	}
+}
+
+const (
+	// Peak activity time of rapid-acting insulin.
+	defaultInsulinPeak = 75 * time.Minute
+
+	// Peak time of the bilinear curve for an insulin action time of 3 hours;
+	// it is scaled proportionally for other action times.
+	bilinearPeak   = 75 * time.Minute
+	bilinearAction = 3 * time.Hour
+
+	// Extended doses are integrated in steps of this size.
+	doseStep = 5 * time.Minute
+)
+
+// InsulinModel specifies how insulin is absorbed.
+type InsulinModel struct {
+	Curve  InsulinCurve
+	Action time.Duration // duration of insulin action, from SettingsInfo.InsulinAction
+	Peak   time.Duration // peak activity time for ExponentialCurve (0 for the default)
+}
+
+// remaining returns the fraction of a dose that remains
+// the given time after it was delivered.
+func (m InsulinModel) remaining(t time.Duration) float64 {
+	if t <= 0 {
+		return 1
+	}
+	if t >= m.Action {
+		return 0
+	}
+	if m.Curve == ExponentialCurve {
+		tau, a, s := m.exponentialParams()
+		x := t.Minutes()
+		td := m.Action.Minutes()
+		return 1 - s*(1-a)*((x*x/(tau*td*(1-a))-x/tau-1)*math.Exp(-x/tau)+1)
+	}
+	end := m.Action.Minutes()
+	peak := m.bilinearPeak().Minutes()
+	x := t.Minutes()
+	if x < peak {
+		return 1 - x*x/(end*peak)
+	}
+	return (end - x) * (end - x) / (end * (end - peak))
+}
+
+// activity returns the fraction of a dose that is absorbed per hour
+// at the given time after it was delivered.
+func (m InsulinModel) activity(t time.Duration) float64 {
+	if t <= 0 || t >= m.Action {
+		return 0
+	}
+	if m.Curve == ExponentialCurve {
+		tau, _, s := m.exponentialParams()
+		x := t.Minutes()
+		td := m.Action.Minutes()
+		return 60 * s / (tau * tau) * x * (1 - x/td) * math.Exp(-x/tau)
+	}
+	end := m.Action.Minutes()
+	peak := m.bilinearPeak().Minutes()
+	x := t.Minutes()
+	if x < peak {
+		return 60 * 2 / end * x / peak
+	}
+	return 60 * 2 / end * (end - x) / (end - peak)
+}
+
+func (m InsulinModel) bilinearPeak() time.Duration {
+	return time.Duration(float64(bilinearPeak) * float64(m.Action) / float64(bilinearAction))
+}
+
+// exponentialParams returns the parameters of the exponential curve
+// (in minutes), as defined by oref0.
+func (m InsulinModel) exponentialParams() (tau, a, s float64) {
+	peak := m.Peak
+	if peak == 0 {
+		peak = defaultInsulinPeak
+	}
+	// The curve requires the action time to be more than twice the peak time.
+	if 2*peak >= m.Action {
+		peak = m.Action / 3
+	}
+	tp := peak.Minutes()
+	td := m.Action.Minutes()
+	tau = tp * (1 - tp/td) / (1 - 2*tp/td)
+	a = 2 * tau / td
+	s = 1 / (1 - a + (1+a)*math.Exp(-td/tau))
+	return tau, a, s
+}
+
+// InsulinDose represents insulin delivered at a constant rate over an interval,
+// or all at once if Duration is zero.
+// Basal doses are relative to the scheduled basal rate, so they may be negative.
+type InsulinDose struct {
+	Start    time.Time
+	Duration time.Duration
+	Amount   Insulin
+	Basal    bool
+}
+
+// IOB represents the insulin on board and insulin activity at a given time.
+type IOB struct {
+	Time     time.Time
+	IOB      Insulin
+	BolusIOB Insulin
+	BasalIOB Insulin
+	Activity Insulin // per hour
+}
+
+// IOB returns the insulin on board and insulin activity at time t
+// resulting from the given doses.
+// Only the part of each dose delivered before t is included.
+func (m InsulinModel) IOB(doses []InsulinDose, t time.Time) IOB {
+	var bolusIOB, basalIOB, activity float64
+	for _, d := range doses {
+		iob, act := m.doseIOB(d, t)
+		if d.Basal {
+			basalIOB += iob
+		} else {
+			bolusIOB += iob
+		}
+		activity += act
+	}
+	return IOB{
+		Time:     t,
+		IOB:      Insulin(math.Round(bolusIOB + basalIOB)),
+		BolusIOB: Insulin(math.Round(bolusIOB)),
+		BasalIOB: Insulin(math.Round(basalIOB)),
+		Activity: Insulin(math.Round(activity)),
+	}
+}
+
+// doseIOB returns the insulin remaining (in milliunits) and the
+// insulin activity (in milliunits per hour) at time t from a single dose.
+func (m InsulinModel) doseIOB(d InsulinDose, t time.Time) (float64, float64) {
+	if !d.Start.Before(t) {
+		return 0, 0
+	}
+	amount := float64(d.Amount)
+	if d.Duration <= 0 {
+		age := t.Sub(d.Start)
+		return amount * m.remaining(age), amount * m.activity(age)
+	}
+	rate := amount / float64(d.Duration)
+	var iob, act float64
+	end := d.Start.Add(d.Duration)
+	if t.Before(end) {
+		end = t
+	}
+	for s := d.Start; s.Before(end); s = s.Add(doseStep) {
+		e := s.Add(doseStep)
+		if e.After(end) {
+			e = end
+		}
+		piece := rate * float64(e.Sub(s))
+		age := t.Sub(s.Add(e.Sub(s) / 2))
+		iob += piece * m.remaining(age)
+		act += piece * m.activity(age)
+	}
+	return iob, act
+}
+
+// InsulinDoses returns the insulin doses recorded in the given history records
+// (in reverse chronological order), in chronological order.
+// Temporary basals and suspensions are converted to net basal doses
+// relative to the given basal rate schedule, using the rates from BasalTimeline.
+// A suspension that has not been resumed is assumed to last until
+// the time of the most recent record.
+func InsulinDoses(records History, basal BasalRateSchedule) []InsulinDose {
+	var doses []InsulinDose
+	for i := len(records) - 1; i >= 0; i-- {
+		if d, ok := bolusDose(records[i]); ok {
+			doses = append(doses, d)
+		}
+	}
+	for _, seg := range BasalTimeline(records) {
+		if seg.Reason == BasalScheduled {
+			continue
+		}
+		rate := seg.Rate
+		doses = append(doses, netBasalDoses(seg.Start, seg.End, basal, func(Insulin) Insulin { return rate })...)
+	}
+	sort.SliceStable(doses, func(i, j int) bool {
+		return doses[i].Start.Before(doses[j].Start)
+	})
+	return doses
+}
+
+// bolusDose returns the dose delivered by a Bolus record,
+// or false if r is not a Bolus record.
+func bolusDose(r HistoryRecord) (InsulinDose, bool) {
+	b, ok := r.Info.(BolusRecord)
+	if r.Type() != Bolus || !ok {
+		return InsulinDose{}, false
+	}
+	d := InsulinDose{Start: r.Time, Amount: b.Amount}
+	if b.Duration != 0 && b.Programmed != 0 {
+		// A square wave bolus that was cancelled delivers
+		// its programmed rate for a shorter time.
+		d.Duration = time.Duration(float64(b.Duration) * float64(b.Amount) / float64(b.Programmed))
+	}
+	return d, true
+}
+
+// netBasalDoses returns the doses, relative to the scheduled basal rate,
+// from delivering the given rate (a function of the scheduled rate)
+// over the interval [start, stop).
+func netBasalDoses(start, stop time.Time, basal BasalRateSchedule, rate func(Insulin) Insulin) []InsulinDose {
+	var doses []InsulinDose
+	for t := start; t.Before(stop); {
+		next := stop
+		var scheduled Insulin
+		if len(basal) != 0 {
+			scheduled = basal[basal.BasalRateAt(t)].Rate
+			if change := basal.NextChange(t); change.Before(next) {
+				next = change
+			}
+		}
+		d := next.Sub(t)
+		net := rate(scheduled) - scheduled
+		if net != 0 {
+			doses = append(doses, InsulinDose{
+				Start:    t,
+				Duration: d,
+				Amount:   Insulin(math.Round(float64(net) * d.Hours())),
+				Basal:    true,
+			})
+		}
+		t = next
+	}
+	return doses
+}
+
+// UnabsorbedCheck compares the bolus insulin on board at the time of an
+// UnabsorbedInsulin record, as computed from the boluses listed in the record,
+// with the bolus insulin on board computed from the history.
+type UnabsorbedCheck struct {
+	Time     time.Time
+	Pump     Insulin
+	Computed Insulin
+}
+
+// CheckUnabsorbed returns a comparison for each UnabsorbedInsulin record
+// in the given history (in reverse chronological order).
+// The pump writes these records for bolus wizard calculations,
+// listing the amount and age of each bolus it considers unabsorbed.
+// Since the model is applied to both sets of boluses, differences
+// indicate boluses that are missing from either the pump's list or the history.
+// UnabsorbedInsulin records have no timestamp,
+// so they use that of the closest earlier record that has one.
+// The pump only counts bolus insulin, so basal doses are ignored.
+func (m InsulinModel) CheckUnabsorbed(records History, doses []InsulinDose) []UnabsorbedCheck {
+	var checks []UnabsorbedCheck
+	var anchor time.Time
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		t := r.Time
+		if t.IsZero() {
+			t = anchor
+		} else if !isDailyTotal(r) {
+			anchor = t
+		}
+		if r.Type() != UnabsorbedInsulin && r.Type() != UnabsorbedInsulin512 {
+			continue
+		}
+		info, ok := r.Info.(UnabsorbedBolusHistory)
+		if !ok || t.IsZero() {
+			continue
+		}
+		var pump []InsulinDose
+		for _, b := range info {
+			pump = append(pump, InsulinDose{Start: t.Add(-time.Duration(b.Age)), Amount: b.Bolus})
+		}
+		checks = append(checks, UnabsorbedCheck{
+			Time:     t,
+			Pump:     m.IOB(pump, t).BolusIOB,
+			Computed: m.IOB(doses, t).BolusIOB,
+		})
+	}
+	return checks
+}
@@ -0,0 +1,143 @@
+package medtronic
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInsulinCurves(t *testing.T) {
+	cases := []InsulinModel{
+		{Curve: BilinearCurve, Action: 3 * time.Hour},
+		{Curve: BilinearCurve, Action: 6 * time.Hour},
+		{Curve: ExponentialCurve, Action: 5 * time.Hour},
+		{Curve: ExponentialCurve, Action: 3 * time.Hour},
+		{Curve: ExponentialCurve, Action: 6 * time.Hour, Peak: 55 * time.Minute},
+	}
+	for _, m := range cases {
+		t.Run(m.Curve.String()+"-"+m.Action.String(), func(t *testing.T) {
+			if m.remaining(0) != 1 || m.remaining(m.Action) != 0 {
+				t.Errorf("remaining(0) = %v, remaining(%v) = %v, want 1 and 0", m.remaining(0), m.Action, m.remaining(m.Action))
+			}
+			// The activity curve must be the rate at which insulin is absorbed.
+			const step = time.Minute
+			absorbed := 0.0
+			prev := 1.0
+			for d := step; d <= m.Action; d += step {
+				absorbed += m.activity(d-step/2) * step.Hours()
+				r := m.remaining(d)
+				if r > prev {
+					t.Errorf("remaining(%v) = %v increased from %v", d, r, prev)
+				}
+				prev = r
+				if math.Abs(1-r-absorbed) > 0.001 {
+					t.Errorf("at %v: absorbed %v, want %v", d, absorbed, 1-r)
+					break
+				}
+			}
+		})
+	}
+}
+
+func testDose(t HistoryRecordType, ts string, info interface{}) HistoryRecord {
+	r := testRecord(t, ts)
+	r.Info = info
+	return r
+}
+
+func TestInsulinDoses(t *testing.T) {
+	basal := BasalRateSchedule{
+		{Start: parseTD("00:00"), Rate: 1000},
+		{Start: parseTD("12:00"), Rate: 1500},
+	}
+	// Records in reverse chronological order.
+	records := History{
+		testRecord(ResumePump, "2020-03-10T14:30:00"),
+		testRecord(SuspendPump, "2020-03-10T14:00:00"),
+		// Superseded by the suspension.
+		testDose(TempBasalDuration, "2020-03-10T13:00:00", Duration(2*time.Hour)),
+		testDose(TempBasalRate, "2020-03-10T13:00:00", TempBasalRecord{Type: Percent, Value: 200}),
+		// Square wave bolus, cancelled halfway through.
+		testDose(Bolus, "2020-03-10T12:30:00", BolusRecord{Programmed: 2000, Amount: 1000, Duration: Duration(time.Hour)}),
+		profileStart("2020-03-10T12:00:00", "12:00", 1500),
+		// Crosses a schedule boundary.
+		testDose(TempBasalDuration, "2020-03-10T11:30:00", Duration(time.Hour)),
+		testDose(TempBasalRate, "2020-03-10T11:30:00", TempBasalRecord{Type: Absolute, Value: Insulin(0)}),
+		testDose(Bolus, "2020-03-10T11:00:00", BolusRecord{Programmed: 3000, Amount: 3000}),
+		profileStart("2020-03-10T10:00:00", "00:00", 1000),
+	}
+	want := []InsulinDose{
+		{Start: parseTime("2020-03-10T11:00:00"), Amount: 3000},
+		{Start: parseTime("2020-03-10T11:30:00"), Duration: 30 * time.Minute, Amount: -500, Basal: true},
+		{Start: parseTime("2020-03-10T12:00:00"), Duration: 30 * time.Minute, Amount: -750, Basal: true},
+		{Start: parseTime("2020-03-10T12:30:00"), Duration: 30 * time.Minute, Amount: 1000},
+		{Start: parseTime("2020-03-10T13:00:00"), Duration: time.Hour, Amount: 1500, Basal: true},
+		{Start: parseTime("2020-03-10T14:00:00"), Duration: 30 * time.Minute, Amount: -750, Basal: true},
+	}
+	doses := InsulinDoses(records, basal)
+	if !reflect.DeepEqual(doses, want) {
+		t.Errorf("InsulinDoses() = %+v, want %+v", doses, want)
+	}
+	m := InsulinModel{Curve: BilinearCurve, Action: 3 * time.Hour}
+	iob := m.IOB(doses, parseTime("2020-03-10T12:45:00"))
+	if iob.IOB != iob.BolusIOB+iob.BasalIOB {
+		t.Errorf("IOB = %v, want %v + %v", iob.IOB, iob.BolusIOB, iob.BasalIOB)
+	}
+	if iob.BasalIOB >= 0 || iob.BolusIOB <= 0 || iob.Activity <= 0 {
+		t.Errorf("IOB = %+v, want negative basal IOB and positive bolus IOB and activity", iob)
+	}
+	iob = m.IOB(doses, parseTime("2020-03-10T18:00:00"))
+	if iob != (IOB{Time: parseTime("2020-03-10T18:00:00")}) {
+		t.Errorf("IOB = %+v, want all insulin absorbed", iob)
+	}
+}
+
+func TestCheckUnabsorbed(t *testing.T) {
+	c := testCase{"ps2", 551, 3}
+	records, err := decodeFromData(testFileName(c)+".json", testPumpFamily(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := InsulinModel{Curve: BilinearCurve, Action: 5 * time.Hour}
+	checks := m.CheckUnabsorbed(records, InsulinDoses(records, nil))
+	if len(checks) != 12 {
+		t.Errorf("CheckUnabsorbed() returned %d results, want 12", len(checks))
+	}
+	// The pump records bolus ages to the minute.
+	const tolerance = 100
+	for _, c := range checks {
+		// Skip the earliest checks, which depend on boluses before the start of the history.
+		if c.Time.Before(parseTime("2016-02-21T15:00:00")) {
+			continue
+		}
+		if math.Abs(float64(c.Computed-c.Pump)) > tolerance {
+			t.Errorf("at %s: computed %v, pump reported %v", c.Time.Format(UserTimeLayout), c.Computed, c.Pump)
+		}
+	}
+}
+
+func TestCheckUnabsorbedMissingBolus(t *testing.T) {
+	unabsorbed := testRecord(UnabsorbedInsulin, "")
+	unabsorbed.Info = UnabsorbedBolusHistory{
+		{Bolus: 2000, Age: Duration(time.Hour)},
+		{Bolus: 1000, Age: Duration(2 * time.Hour)},
+	}
+	// Records in reverse chronological order.
+	records := History{
+		unabsorbed,
+		testDose(BolusWizard, "2020-03-10T13:00:00", BolusWizardRecord{}),
+		testDose(Bolus, "2020-03-10T12:00:00", BolusRecord{Programmed: 2000, Amount: 2000}),
+	}
+	m := InsulinModel{Curve: BilinearCurve, Action: 3 * time.Hour}
+	checks := m.CheckUnabsorbed(records, InsulinDoses(records, nil))
+	if len(checks) != 1 || !checks[0].Time.Equal(parseTime("2020-03-10T13:00:00")) {
+		t.Fatalf("CheckUnabsorbed() = %+v", checks)
+	}
+	// The bolus at 11:00 is missing from the history.
+	c := checks[0]
+	missing := m.IOB([]InsulinDose{{Start: parseTime("2020-03-10T11:00:00"), Amount: 1000}}, c.Time).BolusIOB
+	if c.Pump-c.Computed != missing {
+		t.Errorf("pump %v - computed %v = %v, want %v", c.Pump, c.Computed, c.Pump-c.Computed, missing)
+	}
+}
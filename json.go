@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"reflect"
 	"time"
 )
 
@@ -11,8 +12,9 @@ import (
 func (r HistoryRecord) MarshalJSON() ([]byte, error) {
 	type Original HistoryRecord
 	rep := struct {
-		Type string
-		Time string `json:",omitempty"`
+		Type     string
+		Time     string `json:",omitempty"`
+		PumpTime string `json:",omitempty"`
 		Original
 	}{
 		Type:     fmt.Sprintf("%v", r.Type()),
@@ -21,15 +23,22 @@ func (r HistoryRecord) MarshalJSON() ([]byte, error) {
 	if !r.Time.IsZero() {
 		rep.Time = r.Time.Format(JSONTimeLayout)
 	}
+	if !r.PumpTime.IsZero() {
+		rep.PumpTime = r.PumpTime.Format(JSONTimeLayout)
+	}
 	return json.Marshal(rep)
 }
 
 // UnmarshalJSON unmarshals HistoryRecord values.
+// The Info field is restored with the type used by the decoder
+// for the record's type (given by its Data, or else by its Type field).
 func (r *HistoryRecord) UnmarshalJSON(data []byte) error {
 	type Original HistoryRecord
 	rep := struct {
-		Type string
-		Time string
+		Type     string
+		Time     string
+		PumpTime string
+		Info     json.RawMessage
 		*Original
 	}{
 		Original: (*Original)(r),
@@ -40,10 +49,125 @@ func (r *HistoryRecord) UnmarshalJSON(data []byte) error {
 	}
 	if rep.Time != "" {
 		r.Time, err = time.Parse(JSONTimeLayout, rep.Time)
-	}
+		if err != nil {
+			return err
+		}
+	}
+	if rep.PumpTime != "" {
+		r.PumpTime, err = time.Parse(JSONTimeLayout, rep.PumpTime)
+		if err != nil {
+			return err
+		}
+	}
+	var t HistoryRecordType
+	if len(r.Data) != 0 {
+		t = r.Type()
+	} else {
+		t, err = parseHistoryRecordType(rep.Type)
+		if err != nil {
+			return err
+		}
+		r.Data = []byte{byte(t)}
+	}
+	r.Info, err = unmarshalInfo(t, rep.Info)
 	return err
 }
 
+// parseHistoryRecordType returns the history record type with the given name.
+func parseHistoryRecordType(name string) (HistoryRecordType, error) {
+	for t := range decode {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown history record type %q", name)
+}
+
+var (
+	boolType     = reflect.TypeOf(false)
+	intType      = reflect.TypeOf(0)
+	insulinType  = reflect.TypeOf(Insulin(0))
+	durationType = reflect.TypeOf(Duration(0))
+)
+
+// historyInfoTypes gives the type of the Info field for each history record type.
+// Record types that are not listed have no Info.
+var historyInfoTypes = map[HistoryRecordType]reflect.Type{
+	Bolus:                   reflect.TypeOf(BolusRecord{}),
+	Prime:                   reflect.TypeOf(PrimeRecord{}),
+	Alarm:                   reflect.TypeOf(AlarmCode(0)),
+	DailyTotal:              insulinType,
+	BasalProfileBefore:      reflect.TypeOf(BasalRateSchedule{}),
+	BasalProfileAfter:       reflect.TypeOf(BasalRateSchedule{}),
+	BGCapture:               reflect.TypeOf(GlucoseRecord{}),
+	SensorAlarm:             intType,
+	ClearAlarm:              intType,
+	ChangeBasalPattern:      intType,
+	TempBasalDuration:       durationType,
+	SetAutoOff:              durationType,
+	EnableChildBlock:        boolType,
+	MaxBolus:                insulinType,
+	EnableRemote:            boolType,
+	MaxBasal:                insulinType,
+	EnableBolusWizard:       boolType,
+	Unknown2E:               reflect.TypeOf(BolusWizardSetupRecord{}),
+	BolusWizard512:          reflect.TypeOf(BolusWizardRecord{}),
+	UnabsorbedInsulin512:    reflect.TypeOf(UnabsorbedBolusHistory{}),
+	TempBasalRate:           reflect.TypeOf(TempBasalRecord{}),
+	LowReservoir:            insulinType,
+	BGReceived512:           reflect.TypeOf(GlucoseRecord{}),
+	ConfirmInsulinChange:    boolType,
+	SensorStatus:            boolType,
+	EnableMeter:             boolType,
+	BGReceived:              reflect.TypeOf(GlucoseRecord{}),
+	MealMarker:              reflect.TypeOf(CarbRecord{}),
+	InsulinMarker:           insulinType,
+	ChangeSensorAlarm:       reflect.TypeOf(SensorAlarmSilenceRecord{}),
+	Sensor55:                reflect.TypeOf(SensorPredictiveAlertsRecord{}),
+	BolusWizardSetup:        reflect.TypeOf(BolusWizardSetupRecord{}),
+	BolusWizard:             reflect.TypeOf(BolusWizardRecord{}),
+	UnabsorbedInsulin:       reflect.TypeOf(UnabsorbedBolusHistory{}),
+	EnableVariableBolus:     boolType,
+	EnableBGReminder:        boolType,
+	EnableAlarmClock:        boolType,
+	ChangeTempBasalType:     reflect.TypeOf(TempBasalType(0)),
+	ChangeAlarmType:         intType,
+	ChangeTimeFormat:        intType,
+	ChangeReservoirWarning:  insulinType, // or durationType
+	EnableBolusReminder:     boolType,
+	SetBolusReminderTime:    boolType,
+	DeleteBolusReminderTime: boolType,
+	DailyTotal515:           reflect.TypeOf(DailyTotalRecord{}),
+	DailyTotal522:           reflect.TypeOf(DailyTotalRecord{}),
+	DailyTotal523:           reflect.TypeOf(DailyTotalRecord{}),
+	ChangeCarbUnits:         intType,
+	BasalProfileStart:       reflect.TypeOf(BasalProfileStartRecord{}),
+	ConnectOtherDevices:     boolType,
+	EnableCaptureEvent:      boolType,
+}
+
+// unmarshalInfo unmarshals the Info field of a history record
+// into the type used by the decoder for the given record type.
+func unmarshalInfo(t HistoryRecordType, data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	infoType := historyInfoTypes[t]
+	if t == ChangeReservoirWarning && data[0] == '"' {
+		// The warning is either an amount of insulin or a duration.
+		infoType = durationType
+	}
+	if infoType == nil {
+		if string(data) == "null" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected info for %v record", t)
+	}
+	v := reflect.New(infoType)
+	err := json.Unmarshal(data, v.Interface())
+	return v.Elem().Interface(), err
+}
+
 // MarshalJSON marshals BolusWizardRecord values.
 func (r BolusWizardRecord) MarshalJSON() ([]byte, error) {
 	type Original BolusWizardRecord
@@ -209,6 +333,34 @@ func (r *TempBasalInfo) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// UnmarshalJSON unmarshals TempBasalRecord values.
+// The Value field is an Insulin amount for absolute temp basals
+// and an int for percent temp basals.
+func (r *TempBasalRecord) UnmarshalJSON(data []byte) error {
+	rep := struct {
+		Type  TempBasalType
+		Value json.RawMessage
+	}{}
+	err := json.Unmarshal(data, &rep)
+	if err != nil {
+		return err
+	}
+	r.Type = rep.Type
+	switch r.Type {
+	case Absolute:
+		var v Insulin
+		err = json.Unmarshal(rep.Value, &v)
+		r.Value = v
+	case Percent:
+		var v int
+		err = json.Unmarshal(rep.Value, &v)
+		r.Value = v
+	default:
+		err = fmt.Errorf("unknown temp basal type %d unmarshaling TempBasalRecord", r.Type)
+	}
+	return err
+}
+
 // MarshalJSON marshals CarbUnitsType values.
 func (r CarbUnitsType) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf(`"%v"`, r)), nil
@@ -368,3 +520,19 @@ func (r *CGMRecord) UnmarshalJSON(data []byte) error {
 	}
 	return err
 }
+
+// UnmarshalJSON unmarshals Backup values,
+// converting older versions to the current one.
+func (b *Backup) UnmarshalJSON(data []byte) error {
+	type Original Backup
+	err := json.Unmarshal(data, (*Original)(b))
+	if err != nil {
+		return err
+	}
+	if b.Version == 1 {
+		// Version 1 backups were always read from a pump.
+		b.HasPumpSettings = true
+		b.Version = BackupVersion
+	}
+	return nil
+}
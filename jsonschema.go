@@ -0,0 +1,314 @@
+package medtronic
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONSchemaVersion is the version of the JSON representation of records
+// described by JSONSchema. It is incremented whenever a change
+// to that representation could break existing parsers.
+const JSONSchemaVersion = 1
+
+//go:generate sh -c "go run ./cmd/jsonschema > schema/v1.json"
+
+type schema = map[string]interface{}
+
+// Types whose schemas are published, in addition to the ones they refer to.
+var schemaTypes = []reflect.Type{
+	reflect.TypeOf(History{}),
+	reflect.TypeOf(CGMHistory{}),
+	reflect.TypeOf(SettingsInfo{}),
+	reflect.TypeOf(TempBasalInfo{}),
+	reflect.TypeOf(BasalRateSchedule{}),
+	reflect.TypeOf(CarbRatioSchedule{}),
+	reflect.TypeOf(InsulinSensitivitySchedule{}),
+	reflect.TypeOf(GlucoseTargetSchedule{}),
+}
+
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(h|m|s|ms|us|µs|ns))+$`
+
+// Schemas for types with custom JSON representations.
+var scalarSchemas = map[reflect.Type]schema{
+	reflect.TypeOf(time.Time{}): {
+		"type":   "string",
+		"format": "date-time",
+	},
+	reflect.TypeOf(time.Duration(0)): {
+		"type":    "string",
+		"pattern": durationPattern,
+	},
+	durationType: {
+		"type":    "string",
+		"pattern": durationPattern,
+	},
+	reflect.TypeOf(TimeOfDay(0)): {
+		"description": "time of day (HH:MM)",
+		"type":        "string",
+		"pattern":     "^([01][0-9]|2[0-3]):[0-5][0-9]$",
+	},
+	insulinType: {
+		"description": "insulin units",
+		"type":        "number",
+	},
+	reflect.TypeOf(Voltage(0)): {
+		"description": "volts",
+		"type":        "number",
+	},
+	reflect.TypeOf(Ratio(0)): {
+		"description": "grams per unit or units per exchange, depending on the carb units",
+		"type":        "number",
+	},
+	reflect.TypeOf(Glucose(0)): {
+		"description": "mg/dL or μmol/L, depending on the glucose units",
+		"type":        "integer",
+	},
+	reflect.TypeOf(Carbs(0)): {
+		"description": "grams or 10x exchanges, depending on the carb units",
+		"type":        "integer",
+	},
+	reflect.TypeOf(CarbUnitsType(0)): {
+		"enum": []string{Grams.String(), Exchanges.String()},
+	},
+	reflect.TypeOf(GlucoseUnitsType(0)): {
+		"enum": []string{MgPerDeciLiter.String(), MMolPerLiter.String()},
+	},
+	reflect.TypeOf(TempBasalType(0)): {
+		"enum": []string{Absolute.String(), Percent.String()},
+	},
+}
+
+// Schemas for struct fields whose representation depends on other fields.
+var fieldSchemas = map[string]schema{
+	"TempBasalRecord.Value": {
+		"description": "insulin units for absolute temp basals, percent for percent temp basals",
+		"type":        "number",
+	},
+}
+
+// JSONSchema returns a JSON schema (draft-07) for the JSON representation
+// of history records, CGM records, settings, temp basals, and schedules.
+// Each of these is described in the "definitions" section under its Go type name,
+// and the Info field of a history record is described separately
+// for each history record type.
+func JSONSchema() ([]byte, error) {
+	g := schemaGenerator{definitions: make(map[string]interface{})}
+	for _, t := range schemaTypes {
+		g.schemaFor(t)
+	}
+	s := schema{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       fmt.Sprintf("github.com/ecc1/medtronic JSON records, version %d", JSONSchemaVersion),
+		"version":     JSONSchemaVersion,
+		"definitions": g.definitions,
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// schemaFor returns the schema for a Go type,
+// adding a definition for it if it is a named type in this package.
+func (g *schemaGenerator) schemaFor(t reflect.Type) schema {
+	if s := scalarSchemas[t]; s != nil {
+		return g.define(t, func() schema { return s })
+	}
+	switch t {
+	case reflect.TypeOf(HistoryRecord{}):
+		return g.define(t, g.historyRecordSchema)
+	case reflect.TypeOf(CGMRecord{}):
+		return g.define(t, g.cgmRecordSchema)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return g.define(t, func() schema { return schema{"type": "integer"} })
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return dataSchema(true)
+		}
+		return g.define(t, func() schema {
+			return schema{
+				"type":  []string{"array", "null"},
+				"items": g.schemaFor(t.Elem()),
+			}
+		})
+	case reflect.Struct:
+		return g.define(t, func() schema { return g.structSchema(t) })
+	case reflect.Interface:
+		return schema{}
+	}
+	panic(fmt.Sprintf("no JSON schema for %v", t))
+}
+
+// define adds a definition for a named type in this package
+// and returns a reference to it.
+// Other types are described by the schema itself.
+func (g *schemaGenerator) define(t reflect.Type, s func() schema) schema {
+	if t.Name() == "" || t.PkgPath() != reflect.TypeOf(HistoryRecord{}).PkgPath() {
+		return s()
+	}
+	ref := schema{"$ref": "#/definitions/" + t.Name()}
+	if _, ok := g.definitions[t.Name()]; !ok {
+		// Reserve the name first in case the type refers to itself.
+		g.definitions[t.Name()] = nil
+		g.definitions[t.Name()] = s()
+	}
+	return ref
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		omitEmpty := false
+		if tag := f.Tag.Get("json"); tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+		}
+		s := fieldSchemas[t.Name()+"."+f.Name]
+		if s == nil {
+			s = g.schemaFor(f.Type)
+		}
+		properties[name] = s
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	return schema{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// dataSchema returns the schema for base64-encoded record data.
+func dataSchema(nullable bool) schema {
+	s := schema{
+		"type":            "string",
+		"contentEncoding": "base64",
+	}
+	if nullable {
+		s["type"] = []string{"string", "null"}
+	}
+	return s
+}
+
+func (g *schemaGenerator) historyRecordSchema() schema {
+	var types []string
+	for t := 0; t < 0x100; t++ {
+		if decode[HistoryRecordType(t)] != nil {
+			types = append(types, HistoryRecordType(t).String())
+		}
+	}
+	timeSchema := g.schemaFor(reflect.TypeOf(time.Time{}))
+	return schema{
+		"type": "object",
+		"properties": schema{
+			"Type":     schema{"enum": types},
+			"Time":     timeSchema,
+			"PumpTime": timeSchema,
+			"Data":     dataSchema(false),
+			"Info":     schema{},
+		},
+		"required":             []string{"Type", "Data"},
+		"additionalProperties": false,
+		"allOf":                g.historyInfoSchemas(),
+	}
+}
+
+// historyInfoSchemas returns conditional schemas for the Info field
+// of each group of history record types that share the same Info type.
+func (g *schemaGenerator) historyInfoSchemas() []schema {
+	groups := make(map[string][]string)
+	infos := make(map[string]schema)
+	for t := 0; t < 0x100; t++ {
+		rt := HistoryRecordType(t)
+		if decode[rt] == nil {
+			continue
+		}
+		var info schema
+		switch infoType := historyInfoTypes[rt]; {
+		case rt == ChangeReservoirWarning:
+			info = schema{"oneOf": []schema{g.schemaFor(insulinType), g.schemaFor(durationType)}}
+		case infoType == nil:
+			info = schema{"type": "null"}
+		default:
+			info = g.schemaFor(infoType)
+		}
+		b, err := json.Marshal(info)
+		if err != nil {
+			panic(err)
+		}
+		key := string(b)
+		groups[key] = append(groups[key], rt.String())
+		infos[key] = info
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	conds := make([]schema, len(keys))
+	for i, k := range keys {
+		conds[i] = schema{
+			"if": schema{
+				"properties": schema{"Type": schema{"enum": groups[k]}},
+			},
+			"then": schema{
+				"properties": schema{"Info": infos[k]},
+			},
+		}
+	}
+	return conds
+}
+
+func (g *schemaGenerator) cgmRecordSchema() schema {
+	var types []string
+	for t := 0; t < 0x100; t++ {
+		name := CGMRecordType(t).String()
+		if !strings.HasPrefix(name, "CGMRecordType(") {
+			types = append(types, name)
+		}
+	}
+	return schema{
+		"type": "object",
+		"properties": schema{
+			"Type":    schema{"enum": types},
+			"Time":    g.schemaFor(reflect.TypeOf(time.Time{})),
+			"Data":    dataSchema(true),
+			"Glucose": schema{"type": "integer"},
+			"Value":   schema{"type": "string"},
+		},
+		"required":             []string{"Type", "Data"},
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,266 @@
+package medtronic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const schemaFile = "schema/v1.json"
+
+func TestJSONSchemaUpToDate(t *testing.T) {
+	b, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(published), b) {
+		t.Errorf("%s is out of date; run go generate", schemaFile)
+	}
+}
+
+func TestJSONSchemaTestData(t *testing.T) {
+	v := loadValidator(t)
+	files, err := filepath.Glob(filepath.Join(testDataDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.Contains(file, "treatments") {
+			// Nightscout treatments, not pump records.
+			continue
+		}
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.validate("History", data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaTypes(t *testing.T) {
+	v := loadValidator(t)
+	rate := Insulin(1500)
+	percent := uint8(50)
+	cases := []struct {
+		definition string
+		value      interface{}
+	}{
+		{"CGMHistory", CGMHistory{
+			{Type: CGMGlucose, Data: []byte{0x40}, Time: parseTime("2020-03-10T12:00:00"), Glucose: 128},
+			{Type: CGMTimestamp, Data: []byte{0x08, 0, 0, 0, 0}, Time: parseTime("2020-03-10T11:55:00"), Value: "lastRF"},
+			{Type: CGMDataEnd},
+		}},
+		{"SettingsInfo", SettingsInfo{
+			AutoOff:              16 * time.Hour,
+			InsulinAction:        4 * time.Hour,
+			InsulinConcentration: 100,
+			MaxBolus:             10000,
+			MaxBasal:             3000,
+			TempBasalType:        Absolute,
+		}},
+		{"TempBasalInfo", TempBasalInfo{Duration: 30 * time.Minute, Type: Absolute, Rate: &rate}},
+		{"TempBasalInfo", TempBasalInfo{Duration: 90 * time.Minute, Type: Percent, Percent: &percent}},
+		{"BasalRateSchedule", BasalRateSchedule{{Start: 0, Rate: 800}, {Start: TimeOfDay(6 * time.Hour), Rate: 1000}}},
+		{"CarbRatioSchedule", CarbRatioSchedule{{Start: 0, Ratio: 150, Units: Grams}}},
+		{"InsulinSensitivitySchedule", InsulinSensitivitySchedule{{Start: 0, Sensitivity: 2500, Units: MMolPerLiter}}},
+		{"GlucoseTargetSchedule", GlucoseTargetSchedule{{Start: 0, Low: 100, High: 120, Units: MgPerDeciLiter}}},
+	}
+	for _, c := range cases {
+		t.Run(c.definition, func(t *testing.T) {
+			data, err := json.Marshal(c.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.validate(c.definition, data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaRejects(t *testing.T) {
+	v := loadValidator(t)
+	cases := []struct {
+		definition string
+		json       string
+	}{
+		{"HistoryRecord", `{"Type":"Bolus","Data":"AQ==","Info":{"Programmed":"1.0"}}`},
+		{"HistoryRecord", `{"Type":"Rewind","Data":"IQ==","Info":true}`},
+		{"HistoryRecord", `{"Type":"NoSuchRecord","Data":"AQ=="}`},
+		{"HistoryRecord", `{"Type":"Rewind"}`},
+		{"TempBasalInfo", `{"Duration":30,"Type":"Absolute","Rate":1.5}`},
+		{"CarbRatioSchedule", `[{"Start":"25:00","Ratio":15,"Units":"Grams"}]`},
+		{"GlucoseTargetSchedule", `[{"Start":"00:00","Low":100,"High":120,"Units":"mmol"}]`},
+	}
+	for _, c := range cases {
+		if err := v.validate(c.definition, []byte(c.json)); err == nil {
+			t.Errorf("%s %s was accepted", c.definition, c.json)
+		}
+	}
+}
+
+// schemaValidator implements the subset of JSON Schema used by JSONSchema.
+type schemaValidator struct {
+	definitions map[string]interface{}
+}
+
+func loadValidator(t *testing.T) schemaValidator {
+	data, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		Definitions map[string]interface{} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return schemaValidator{definitions: s.Definitions}
+}
+
+func (v schemaValidator) validate(definition string, data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return v.check(v.definitions[definition], value, definition)
+}
+
+func (v schemaValidator) check(s interface{}, value interface{}, path string) error {
+	sm, ok := s.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: invalid schema %v", path, s)
+	}
+	if ref, ok := sm["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		return v.check(v.definitions[name], value, path)
+	}
+	if t, ok := sm["type"]; ok && !matchesType(t, value) {
+		return fmt.Errorf("%s: %v does not have type %v", path, value, t)
+	}
+	if enum, ok := sm["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+	if pattern, ok := sm["pattern"].(string); ok {
+		if str, ok := value.(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s: %q does not match %s", path, str, pattern)
+		}
+	}
+	if obj, ok := value.(map[string]interface{}); ok {
+		if err := v.checkObject(sm, obj, path); err != nil {
+			return err
+		}
+	}
+	if items, ok := sm["items"]; ok {
+		if arr, ok := value.([]interface{}); ok {
+			for i, e := range arr {
+				if err := v.check(items, e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if oneOf, ok := sm["oneOf"].([]interface{}); ok {
+		n := 0
+		for _, alt := range oneOf {
+			if v.check(alt, value, path) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("%s: %v matches %d alternatives", path, value, n)
+		}
+	}
+	if allOf, ok := sm["allOf"].([]interface{}); ok {
+		for _, cond := range allOf {
+			if err := v.check(cond, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if cond, ok := sm["if"]; ok && v.check(cond, value, path) == nil {
+		if then, ok := sm["then"]; ok {
+			return v.check(then, value, path)
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) checkObject(s map[string]interface{}, obj map[string]interface{}, path string) error {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", path, r)
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	for k, e := range obj {
+		p, ok := properties[k]
+		if !ok {
+			if s["additionalProperties"] == false {
+				return fmt.Errorf("%s: unexpected property %s", path, k)
+			}
+			continue
+		}
+		if err := v.check(p, e, path+"."+k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	if types, ok := t.([]interface{}); ok {
+		for _, u := range types {
+			if matchesType(u, value) {
+				return true
+			}
+		}
+		return false
+	}
+	switch t {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return false
+}
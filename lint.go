@@ -0,0 +1,242 @@
+package medtronic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum number of entries in a basal rate schedule.
+	maxBasalEntries = 48
+
+	// Shortest plausible duration of insulin action for rapid-acting insulin.
+	minRapidInsulinAction = 3 * time.Hour
+)
+
+// Plausible ranges for bolus wizard settings.
+// Values outside these ranges are flagged as warnings rather than errors.
+var (
+	minCarbRatioGrams     = Ratio(30)   // 3 g/U
+	maxCarbRatioGrams     = Ratio(1500) // 150 g/U
+	minCarbRatioExchanges = Ratio(100)  // 0.1 U/exch
+	maxCarbRatioExchanges = Ratio(5000) // 5 U/exch
+
+	minSensitivityMgPerDeciLiter = Glucose(5)
+	maxSensitivityMgPerDeciLiter = Glucose(400)
+	minSensitivityMMolPerLiter   = Glucose(300)   // 0.3 mmol/L
+	maxSensitivityMMolPerLiter   = Glucose(22000) // 22 mmol/L
+)
+
+// LintIssue represents a problem found in a set of pump settings.
+// Start is nil for problems that are not specific to a schedule entry.
+// Warnings indicate implausible values that the pump will nevertheless accept.
+type LintIssue struct {
+	Setting string
+	Start   *TimeOfDay `json:",omitempty"`
+	Message string
+	Warning bool
+}
+
+func (i LintIssue) String() string {
+	s := fmt.Sprintf("%s: %s", i.Setting, i.Message)
+	if i.Start != nil {
+		s = i.Start.String() + " " + s
+	}
+	if i.Warning {
+		s = "warning: " + s
+	}
+	return s
+}
+
+// LintIssues represents the result of checking a set of pump settings.
+type LintIssues []LintIssue
+
+// Errors returns the issues that are not warnings.
+func (v LintIssues) Errors() LintIssues {
+	var errs LintIssues
+	for _, i := range v {
+		if !i.Warning {
+			errs = append(errs, i)
+		}
+	}
+	return errs
+}
+
+// Err returns an error describing the issues that are not warnings,
+// or nil if there are none.
+func (v LintIssues) Err() error {
+	errs := v.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.String()
+	}
+	return fmt.Errorf("invalid settings: %s", strings.Join(msgs, "; "))
+}
+
+type linter struct {
+	issues LintIssues
+}
+
+func (l *linter) add(warning bool, setting string, start *TimeOfDay, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{
+		Setting: setting,
+		Start:   start,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+func (l *linter) error(setting string, start *TimeOfDay, format string, args ...interface{}) {
+	l.add(false, setting, start, format, args...)
+}
+
+func (l *linter) warning(setting string, start *TimeOfDay, format string, args ...interface{}) {
+	l.add(true, setting, start, format, args...)
+}
+
+// checkStarts checks that a schedule begins at 00:00,
+// has strictly increasing start times, and is not too long.
+func (l *linter) checkStarts(setting string, starts []TimeOfDay, maxEntries int) {
+	if len(starts) == 0 {
+		return
+	}
+	if starts[0] != 0 {
+		l.error(setting, &starts[0], "schedule must begin at 00:00")
+	}
+	for i := 1; i < len(starts); i++ {
+		if starts[i] <= starts[i-1] {
+			l.error(setting, &starts[i], "schedule is not sorted (follows %v)", starts[i-1])
+		}
+	}
+	if len(starts) > maxEntries {
+		l.error(setting, nil, "schedule has %d entries (at most %d are allowed)", len(starts), maxEntries)
+	}
+}
+
+// LintSettings checks a set of pump settings for a pump of the given family
+// for values that the pump will reject or that are implausible.
+// Schedules and settings that are empty or zero are not checked,
+// so it can also be used to check a subset of the settings,
+// such as a single basal schedule and the max basal rate.
+func LintSettings(b Backup, family Family) LintIssues {
+	var l linter
+	for i, s := range b.basalSchedules() {
+		l.lintBasalRates(basalPatternNames[i], s, b.Settings.MaxBasal)
+	}
+	l.lintCarbRatios(b.CarbRatios)
+	l.lintSensitivities(b.Sensitivities)
+	l.lintTargets(b.Targets, family)
+	l.lintInsulinAction(b.Settings.InsulinAction, family)
+	return l.issues
+}
+
+func (l *linter) lintBasalRates(name string, s BasalRateSchedule, maxBasal Insulin) {
+	starts := make([]TimeOfDay, len(s))
+	for i, r := range s {
+		starts[i] = r.Start
+		t := r.Start
+		start := &t
+		if r.Rate < 0 {
+			l.error(name, start, "rate %v is negative", r.Rate)
+		} else if maxBasal != 0 && r.Rate > maxBasal {
+			l.error(name, start, "rate %v exceeds max basal rate %v", r.Rate, maxBasal)
+		}
+	}
+	l.checkStarts(name, starts, maxBasalEntries)
+}
+
+func (l *linter) lintCarbRatios(s CarbRatioSchedule) {
+	const name = "carb ratio"
+	starts := make([]TimeOfDay, len(s))
+	for i, r := range s {
+		starts[i] = r.Start
+		t := r.Start
+		start := &t
+		if r.Units != s[0].Units {
+			l.error(name, start, "inconsistent units (%v and %v)", s[0].Units, r.Units)
+			continue
+		}
+		if r.Ratio <= 0 {
+			l.error(name, start, "%s is not positive", formatRatio(r.Ratio, r.Units))
+			continue
+		}
+		lo, hi := minCarbRatioGrams, maxCarbRatioGrams
+		if r.Units == Exchanges {
+			lo, hi = minCarbRatioExchanges, maxCarbRatioExchanges
+		}
+		if r.Ratio < lo || r.Ratio > hi {
+			l.warning(name, start, "%s is outside the plausible range %s to %s",
+				formatRatio(r.Ratio, r.Units), formatRatio(lo, r.Units), formatRatio(hi, r.Units))
+		}
+	}
+	l.checkStarts(name, starts, maxScheduleEntries)
+}
+
+func (l *linter) lintSensitivities(s InsulinSensitivitySchedule) {
+	const name = "insulin sensitivity"
+	starts := make([]TimeOfDay, len(s))
+	for i, r := range s {
+		starts[i] = r.Start
+		t := r.Start
+		start := &t
+		if r.Units != s[0].Units {
+			l.error(name, start, "inconsistent units (%v and %v)", s[0].Units, r.Units)
+			continue
+		}
+		if r.Sensitivity <= 0 {
+			l.error(name, start, "%s is not positive", formatGlucose(r.Sensitivity, r.Units))
+			continue
+		}
+		lo, hi := minSensitivityMgPerDeciLiter, maxSensitivityMgPerDeciLiter
+		if r.Units == MMolPerLiter {
+			lo, hi = minSensitivityMMolPerLiter, maxSensitivityMMolPerLiter
+		}
+		if r.Sensitivity < lo || r.Sensitivity > hi {
+			l.warning(name, start, "%s is outside the plausible range %s to %s",
+				formatGlucose(r.Sensitivity, r.Units), formatGlucose(lo, r.Units), formatGlucose(hi, r.Units))
+		}
+	}
+	l.checkStarts(name, starts, maxScheduleEntries)
+}
+
+func (l *linter) lintTargets(s GlucoseTargetSchedule, family Family) {
+	const name = "glucose target"
+	starts := make([]TimeOfDay, len(s))
+	for i, r := range s {
+		starts[i] = r.Start
+		t := r.Start
+		start := &t
+		if r.Units != s[0].Units {
+			l.error(name, start, "inconsistent units (%v and %v)", s[0].Units, r.Units)
+			continue
+		}
+		if r.Low <= 0 {
+			l.error(name, start, "low target %s is not positive", formatGlucose(r.Low, r.Units))
+		}
+		if r.Low > r.High {
+			l.error(name, start, "low target %s is greater than high target %s",
+				formatGlucose(r.Low, r.Units), formatGlucose(r.High, r.Units))
+		} else if family <= 12 && r.Low != r.High {
+			l.error(name, start, "model x12 pumps do not support a glucose target range")
+		}
+	}
+	l.checkStarts(name, starts, maxScheduleEntries)
+}
+
+func (l *linter) lintInsulinAction(d time.Duration, family Family) {
+	const name = "insulin action"
+	if d == 0 {
+		return
+	}
+	if _, err := encodeInsulinAction(d, family); err != nil {
+		l.error(name, nil, "%v", err)
+		return
+	}
+	if d < minRapidInsulinAction {
+		l.warning(name, nil, "%v is shorter than the action of rapid-acting insulin", d)
+	}
+}
@@ -0,0 +1,79 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLintSettings(t *testing.T) {
+	cases := []struct {
+		settings Backup
+		family   Family
+		issues   []string
+	}{
+		{
+			testBackup(),
+			23,
+			nil,
+		},
+		{
+			Backup{
+				Settings: SettingsInfo{MaxBasal: 2000},
+				BasalRates: BasalRateSchedule{
+					{parseTD("00:30"), 1000},
+					{parseTD("06:00"), 2500},
+					{parseTD("05:00"), 1000},
+				},
+			},
+			23,
+			[]string{
+				"06:00 standard basal: rate 2.500 exceeds max basal rate 2.000",
+				"00:30 standard basal: schedule must begin at 00:00",
+				"05:00 standard basal: schedule is not sorted (follows 06:00)",
+			},
+		},
+		{
+			Backup{
+				CarbRatios:    CarbRatioSchedule{{parseTD("00:00"), 2000, Grams}},
+				Sensitivities: InsulinSensitivitySchedule{{parseTD("00:00"), 2, MgPerDeciLiter}},
+				Targets: GlucoseTargetSchedule{
+					{parseTD("00:00"), 120, 100, MgPerDeciLiter},
+					{parseTD("12:00"), 100, 100, MMolPerLiter},
+				},
+			},
+			23,
+			[]string{
+				"warning: 00:00 carb ratio: 200.0 g/U is outside the plausible range 3.0 g/U to 150.0 g/U",
+				"warning: 00:00 insulin sensitivity: 2 mg/dL is outside the plausible range 5 mg/dL to 400 mg/dL",
+				"00:00 glucose target: low target 120 mg/dL is greater than high target 100 mg/dL",
+				"12:00 glucose target: inconsistent units (mg/dL and μmol/L)",
+			},
+		},
+		{
+			Backup{Settings: SettingsInfo{InsulinAction: 2 * time.Hour}},
+			23,
+			[]string{
+				"warning: insulin action: 2h0m0s is shorter than the action of rapid-acting insulin",
+			},
+		},
+		{
+			Backup{Settings: SettingsInfo{InsulinAction: 4 * time.Hour}},
+			12,
+			[]string{
+				"insulin action: insulin action (4h0m0s) must be 6h or 8h for model x12 pumps",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			var issues []string
+			for _, i := range LintSettings(c.settings, c.family) {
+				issues = append(issues, i.String())
+			}
+			if !reflect.DeepEqual(issues, c.issues) {
+				t.Errorf("LintSettings(%+v, %d) ==\n%q\nwant\n%q", c.settings, c.family, issues, c.issues)
+			}
+		})
+	}
+}
@@ -5,15 +5,12 @@ import (
 	"log"
 )
 
-// SetMaxBasal sets the pump's maximum basal rate.
-func (pump *Pump) SetMaxBasal(rate Insulin) {
+func encodeMaxBasal(rate Insulin) (uint16, error) {
 	if rate < 0 {
-		pump.SetError(fmt.Errorf("max basal rate (%d) is negative", rate))
-		return
+		return 0, fmt.Errorf("max basal rate (%d) is negative", rate)
 	}
 	if rate > maxBasal {
-		pump.SetError(fmt.Errorf("max basal rate (%d) is too large", rate))
-		return
+		return 0, fmt.Errorf("max basal rate (%d) is too large", rate)
 	}
 	m := milliUnitsPerStroke(23)
 	strokes := rate / m
@@ -21,5 +18,15 @@ func (pump *Pump) SetMaxBasal(rate Insulin) {
 	if actual != rate {
 		log.Printf("rounding max basal rate from %v to %v", rate, actual)
 	}
-	pump.Execute(setMaxBasal, marshalUint16(uint16(strokes))...)
+	return uint16(strokes), nil
+}
+
+// SetMaxBasal sets the pump's maximum basal rate.
+func (pump *Pump) SetMaxBasal(rate Insulin) {
+	strokes, err := encodeMaxBasal(rate)
+	if err != nil {
+		pump.SetError(err)
+		return
+	}
+	pump.Execute(setMaxBasal, marshalUint16(strokes)...)
 }
@@ -5,16 +5,12 @@ import (
 	"log"
 )
 
-// SetMaxBolus sets the pump's maximum bolus.
-func (pump *Pump) SetMaxBolus(amount Insulin) {
+func encodeMaxBolus(amount Insulin) (uint8, error) {
 	if amount < 0 {
-		pump.SetError(fmt.Errorf("bolus amount (%d) is negative", amount))
+		return 0, fmt.Errorf("bolus amount (%d) is negative", amount)
 	}
 	if amount > maxBolus {
-		pump.SetError(fmt.Errorf("bolus amount (%d) is too large", amount))
-	}
-	if pump.Error() != nil {
-		return
+		return 0, fmt.Errorf("bolus amount (%d) is too large", amount)
 	}
 	m := milliUnitsPerStroke(22)
 	strokes := amount / m
@@ -22,5 +18,15 @@ func (pump *Pump) SetMaxBolus(amount Insulin) {
 	if actual != amount {
 		log.Printf("rounding max bolus from %v to %v", amount, actual)
 	}
-	pump.Execute(setMaxBolus, uint8(strokes))
+	return uint8(strokes), nil
+}
+
+// SetMaxBolus sets the pump's maximum bolus.
+func (pump *Pump) SetMaxBolus(amount Insulin) {
+	strokes, err := encodeMaxBolus(amount)
+	if err != nil {
+		pump.SetError(err)
+		return
+	}
+	pump.Execute(setMaxBolus, strokes)
 }
@@ -1,7 +1,12 @@
 package medtronic
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ecc1/nightscout"
@@ -189,6 +194,149 @@ func (sched GlucoseTargetSchedule) NightscoutSchedule() (nightscout.Schedule, ni
 	return low, high
 }
 
+// ProfileSettings converts the default profile in a Nightscout profile document
+// into a Backup containing the corresponding schedules and insulin action.
+// Other settings are left unset, so DiffSettings will not compare them.
+func ProfileSettings(p nightscout.Profile) (Backup, error) {
+	data, found := p.Store[p.DefaultProfile]
+	if !found {
+		return Backup{}, fmt.Errorf("Nightscout profile %q not found", p.DefaultProfile)
+	}
+	units, err := profileGlucoseUnits(data.Units)
+	if err != nil {
+		return Backup{}, err
+	}
+	b := Backup{
+		Version:      BackupVersion,
+		Time:         p.StartDate,
+		CarbUnits:    Grams,
+		GlucoseUnits: units,
+	}
+	b.Settings.InsulinAction = time.Duration(data.DIA) * time.Hour
+	err = forEachProfileValue(data.Basal, func(t TimeOfDay, v float64) {
+		b.BasalRates = append(b.BasalRates, BasalRate{Start: t, Rate: Insulin(math.Round(1000 * v))})
+	})
+	if err != nil {
+		return Backup{}, fmt.Errorf("basal: %v", err)
+	}
+	err = forEachProfileValue(data.CarbRatio, func(t TimeOfDay, v float64) {
+		b.CarbRatios = append(b.CarbRatios, CarbRatio{Start: t, Ratio: Ratio(math.Round(10 * v)), Units: Grams})
+	})
+	if err != nil {
+		return Backup{}, fmt.Errorf("carb ratio: %v", err)
+	}
+	err = forEachProfileValue(data.Sens, func(t TimeOfDay, v float64) {
+		b.Sensitivities = append(b.Sensitivities, InsulinSensitivity{Start: t, Sensitivity: profileGlucose(v, units), Units: units})
+	})
+	if err != nil {
+		return Backup{}, fmt.Errorf("sensitivity: %v", err)
+	}
+	b.Targets, err = profileTargets(data.TargetLow, data.TargetHigh, units)
+	if err != nil {
+		return Backup{}, err
+	}
+	return b, nil
+}
+
+func profileGlucoseUnits(s string) (GlucoseUnitsType, error) {
+	switch strings.ToLower(s) {
+	case "mg/dl":
+		return MgPerDeciLiter, nil
+	case "mmol", "mmol/l":
+		return MMolPerLiter, nil
+	default:
+		return 0, fmt.Errorf("unknown Nightscout profile units %q", s)
+	}
+}
+
+func profileGlucose(v float64, units GlucoseUnitsType) Glucose {
+	if units == MMolPerLiter {
+		// Convert mmol/L to μmol/L.
+		return Glucose(math.Round(1000 * v))
+	}
+	return Glucose(math.Round(v))
+}
+
+// forEachProfileValue calls fn with the start time and numeric value
+// of each entry in a Nightscout schedule.
+// Values may be either JSON numbers or strings.
+func forEachProfileValue(sched nightscout.Schedule, fn func(TimeOfDay, float64)) error {
+	for _, tv := range sched {
+		t, err := ParseTimeOfDay(tv.Time)
+		if err != nil {
+			return err
+		}
+		var v float64
+		switch x := tv.Value.(type) {
+		case float64:
+			v = x
+		case string:
+			v, err = strconv.ParseFloat(x, 64)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected value %v at %s", tv.Value, tv.Time)
+		}
+		fn(t, v)
+	}
+	return nil
+}
+
+// profileTargets merges the separate low and high target schedules
+// in a Nightscout profile into a single GlucoseTargetSchedule.
+func profileTargets(lowSched, highSched nightscout.Schedule, units GlucoseUnitsType) (GlucoseTargetSchedule, error) {
+	low, err := profileGlucoseSchedule(lowSched, units)
+	if err != nil {
+		return nil, fmt.Errorf("target low: %v", err)
+	}
+	high, err := profileGlucoseSchedule(highSched, units)
+	if err != nil {
+		return nil, fmt.Errorf("target high: %v", err)
+	}
+	var starts []TimeOfDay
+	for t := range low {
+		starts = append(starts, t)
+	}
+	for t := range high {
+		if _, found := low[t]; !found {
+			starts = append(starts, t)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	var sched GlucoseTargetSchedule
+	var l, h Glucose
+	for _, t := range starts {
+		if g, found := low[t]; found {
+			l = g
+		}
+		if g, found := high[t]; found {
+			h = g
+		}
+		if l == 0 || h == 0 {
+			return nil, fmt.Errorf("target range at %v has no low or high value", t)
+		}
+		if len(sched) != 0 {
+			prev := sched[len(sched)-1]
+			if prev.Low == l && prev.High == h {
+				continue
+			}
+		}
+		sched = append(sched, GlucoseTarget{Start: t, Low: l, High: h, Units: units})
+	}
+	return sched, nil
+}
+
+// profileGlucoseSchedule returns the glucose values in a Nightscout schedule
+// indexed by their start times.
+func profileGlucoseSchedule(sched nightscout.Schedule, units GlucoseUnitsType) (map[TimeOfDay]Glucose, error) {
+	m := make(map[TimeOfDay]Glucose)
+	err := forEachProfileValue(sched, func(t TimeOfDay, v float64) {
+		m[t] = profileGlucose(v, units)
+	})
+	return m, err
+}
+
 // NightscoutEntries converts certain CGM history records
 // into records that can be uploaded as Nightscout entries.
 // The nightscout.Trend calculation assumes that
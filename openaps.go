@@ -0,0 +1,192 @@
+package medtronic
+
+import (
+	"encoding/base64"
+	"time"
+)
+
+// OpenAPSRecord represents a history record in the format produced by decocare,
+// which is what oref0 expects in pumphistory.json.
+// Only the fields for the record's type are present.
+type OpenAPSRecord struct {
+	Timestamp              string   `json:"timestamp"`
+	Type                   string   `json:"_type"`
+	ID                     string   `json:"id"`
+	DurationMinutes        *int     `json:"duration (min),omitempty"`
+	Temp                   string   `json:"temp,omitempty"`
+	Rate                   *float64 `json:"rate,omitempty"`
+	Link                   string   `json:"link,omitempty"`
+	Amount                 *float64 `json:"amount,omitempty"`
+	Programmed             *float64 `json:"programmed,omitempty"`
+	Unabsorbed             *float64 `json:"unabsorbed,omitempty"`
+	Duration               *int     `json:"duration,omitempty"`
+	Fixed                  *float64 `json:"fixed,omitempty"`
+	PrimeType              string   `json:"type,omitempty"`
+	BG                     *float64 `json:"bg,omitempty"`
+	CarbInput              *int     `json:"carb_input,omitempty"`
+	BGTargetLow            *float64 `json:"bg_target_low,omitempty"`
+	BGTargetHigh           *float64 `json:"bg_target_high,omitempty"`
+	Sensitivity            *float64 `json:"sensitivity,omitempty"`
+	CarbRatio              *float64 `json:"carb_ratio,omitempty"`
+	CorrectionEstimate     *float64 `json:"correction_estimate,omitempty"`
+	FoodEstimate           *float64 `json:"food_estimate,omitempty"`
+	UnabsorbedInsulinTotal *float64 `json:"unabsorbed_insulin_total,omitempty"`
+	BolusEstimate          *float64 `json:"bolus_estimate,omitempty"`
+	Units                  string   `json:"units,omitempty"`
+}
+
+// Record types whose decocare names differ from ours.
+var openAPSType = map[HistoryRecordType]string{
+	TempBasalRate:  "TempBasal",
+	BolusWizard512: "BolusWizard",
+	InsulinMarker:  "JournalEntryInsulinMarker",
+	MealMarker:     "JournalEntryMealMarker",
+	BGCapture:      "CalBGForPH",
+	SuspendPump:    "PumpSuspend",
+	ResumePump:     "PumpResume",
+	BatteryChange:  "Battery",
+}
+
+// Meter ID used by fakemeter.
+const fakeMeterID = "000000"
+
+// OpenAPSHistory converts the history records read by oref0
+// into the format that it expects, in the same order.
+// Other records are omitted, as are glucose values sent to the pump
+// by fakemeter (along with the BGCapture records they produce),
+// since oref0 already has those values.
+func OpenAPSHistory(records History) []OpenAPSRecord {
+	fromFakeMeter := fakeMeterFilter(records)
+	// Marshal as an empty array rather than null.
+	results := []OpenAPSRecord{}
+	for _, r := range records {
+		if fromFakeMeter(r) {
+			continue
+		}
+		if o, ok := openAPSRecord(r); ok {
+			results = append(results, o)
+		}
+	}
+	return results
+}
+
+func isFakeMeterRecord(r HistoryRecord) bool {
+	return r.Type() == BGReceived && r.Info.(GlucoseRecord).MeterID == fakeMeterID
+}
+
+// fakeMeterFilter returns a function that reports whether a record
+// is a glucose value sent to the pump by fakemeter,
+// or a BGCapture record produced by one.
+func fakeMeterFilter(records History) func(HistoryRecord) bool {
+	fakeMeterTimes := make(map[time.Time]bool)
+	for _, r := range records {
+		if isFakeMeterRecord(r) {
+			fakeMeterTimes[r.Time] = true
+		}
+	}
+	return func(r HistoryRecord) bool {
+		return isFakeMeterRecord(r) || (r.Type() == BGCapture && fakeMeterTimes[r.Time])
+	}
+}
+
+func openAPSRecord(r HistoryRecord) (OpenAPSRecord, bool) {
+	t := r.Type()
+	o := OpenAPSRecord{
+		Timestamp: r.Time.Format(JSONTimeLayout),
+		Type:      t.String(),
+		ID:        base64.StdEncoding.EncodeToString(r.Data),
+	}
+	if name := openAPSType[t]; name != "" {
+		o.Type = name
+	}
+	switch t {
+	case TempBasalDuration:
+		o.DurationMinutes = openAPSMinutes(r.Info.(Duration))
+	case TempBasalRate:
+		tb := r.Info.(TempBasalRecord)
+		o.Temp = "absolute"
+		if tb.Type == Absolute {
+			o.Rate = openAPSInsulin(tb.Value.(Insulin))
+		} else {
+			o.Temp = "percent"
+			o.Rate = openAPSFloat(float64(tb.Value.(int)))
+		}
+	case Bolus:
+		info := r.Info.(BolusRecord)
+		o.Amount = openAPSInsulin(info.Amount)
+		o.Programmed = openAPSInsulin(info.Programmed)
+		o.Unabsorbed = openAPSInsulin(info.Unabsorbed)
+		o.Duration = openAPSMinutes(info.Duration)
+	case BolusWizard, BolusWizard512:
+		info := r.Info.(BolusWizardRecord)
+		carbs := int(info.CarbInput)
+		o.CarbInput = &carbs
+		o.CarbRatio = openAPSFloat(float64(info.CarbRatio) / 10)
+		if info.CarbUnits == Exchanges {
+			o.CarbRatio = openAPSFloat(float64(info.CarbRatio) / 1000)
+		}
+		o.CorrectionEstimate = openAPSInsulin(info.Correction)
+		o.FoodEstimate = openAPSInsulin(info.Food)
+		o.UnabsorbedInsulinTotal = openAPSInsulin(info.Unabsorbed)
+		o.BolusEstimate = openAPSInsulin(info.Bolus)
+		u := info.GlucoseUnits
+		o.BG = openAPSGlucose(info.GlucoseInput, u)
+		o.BGTargetLow = openAPSGlucose(info.TargetLow, u)
+		o.BGTargetHigh = openAPSGlucose(info.TargetHigh, u)
+		o.Sensitivity = openAPSGlucose(info.Sensitivity, u)
+		o.Units = openAPSUnits(u)
+	case InsulinMarker:
+		o.Amount = openAPSInsulin(r.Info.(Insulin))
+	case MealMarker:
+		carbs := int(r.Info.(CarbRecord).Carbs)
+		o.CarbInput = &carbs
+	case Prime:
+		info := r.Info.(PrimeRecord)
+		o.Amount = openAPSInsulin(info.Manual)
+		o.Fixed = openAPSInsulin(info.Fixed)
+		o.PrimeType = "fixed"
+		if info.Fixed == 0 {
+			o.PrimeType = "manual"
+		}
+	case BGCapture, BGReceived:
+		info := r.Info.(GlucoseRecord)
+		if t == BGReceived {
+			o.Link = info.MeterID
+		}
+		o.Amount = openAPSGlucose(info.Glucose, info.Units)
+		o.Units = openAPSUnits(info.Units)
+	case SuspendPump, ResumePump, BatteryChange, Rewind:
+	default:
+		return o, false
+	}
+	return o, true
+}
+
+func openAPSFloat(v float64) *float64 {
+	return &v
+}
+
+func openAPSInsulin(r Insulin) *float64 {
+	return openAPSFloat(float64(r) / 1000)
+}
+
+func openAPSMinutes(d Duration) *int {
+	m := int(time.Duration(d) / time.Minute)
+	return &m
+}
+
+// openAPSGlucose converts a glucose value to mg/dL or mmol/L.
+func openAPSGlucose(g Glucose, u GlucoseUnitsType) *float64 {
+	if u == MMolPerLiter {
+		// Glucose values in mmol/L are represented in μmol/L.
+		return openAPSFloat(float64(g) / 1000)
+	}
+	return openAPSFloat(float64(g))
+}
+
+func openAPSUnits(u GlucoseUnitsType) string {
+	if u == MMolPerLiter {
+		return "mmol"
+	}
+	return "mgdl"
+}
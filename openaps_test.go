@@ -0,0 +1,21 @@
+package medtronic
+
+import (
+	"testing"
+)
+
+func TestOpenAPSHistory(t *testing.T) {
+	for _, c := range openAPSTestCases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			records, err := decodeFromData(testFile+".json", testPumpFamily(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			eq, msg := compareDataToJSON(OpenAPSHistory(records), testFile+".openaps")
+			if !eq {
+				t.Errorf("JSON is different:\n%s", msg)
+			}
+		})
+	}
+}
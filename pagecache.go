@@ -0,0 +1,164 @@
+package medtronic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// PageCache holds verified history pages from previous downloads,
+// so that pages that have not changed need not be downloaded again.
+//
+// History page 0 is the one the pump is currently writing to.
+// When it fills up, every page shifts to the next higher number,
+// so the contents of a complete page never change, only its page number.
+// After downloading page 0 (which is never taken from the cache),
+// pages are downloaded until one has the same contents as exactly one
+// complete page from the previous session; the difference in page numbers
+// is the number of times the pages have rolled, and the remaining pages
+// are served from the cache.
+type PageCache struct {
+	Version int
+	PumpID  string
+	Pages   map[int][]byte // contents of each page number
+
+	prev   map[int][]byte // pages from the previous session
+	offset int            // number of times the pages have rolled, or -1 if unknown
+}
+
+// Version of the page cache format.
+// Caches in other formats are discarded.
+const pageCacheVersion = 2
+
+// NewPageCache returns an empty page cache.
+func NewPageCache() *PageCache {
+	return &PageCache{
+		Version: pageCacheVersion,
+		Pages:   make(map[int][]byte),
+		offset:  -1,
+	}
+}
+
+// LoadPageCache reads a page cache from the given file.
+// If the file does not exist or is in an older format,
+// an empty cache is returned.
+func LoadPageCache(path string) (*PageCache, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewPageCache(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &PageCache{}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if c.Version != pageCacheVersion {
+		log.Printf("%s: discarding page cache in format version %d", path, c.Version)
+		return NewPageCache(), nil
+	}
+	if c.Pages == nil {
+		c.Pages = make(map[int][]byte)
+	}
+	c.offset = -1
+	return c, nil
+}
+
+// Save writes the page cache to the given file,
+// including cached pages that were not needed in this session.
+func (c *PageCache) Save(path string) error {
+	if c.offset >= 0 {
+		for j, data := range c.prev {
+			i := j + c.offset
+			if j == 0 || i >= MaxHistoryPages {
+				continue
+			}
+			if _, found := c.Pages[i]; !found {
+				c.Pages[i] = data
+			}
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// start begins a new session for the pump with the given ID.
+func (c *PageCache) start(pumpID string) {
+	if c.PumpID != pumpID {
+		if c.PumpID != "" {
+			log.Printf("discarding page cache for pump %s", c.PumpID)
+		}
+		c.Pages = make(map[int][]byte)
+		c.PumpID = pumpID
+	}
+	c.prev = c.Pages
+	c.Pages = make(map[int][]byte)
+	c.offset = -1
+}
+
+// SetPageCache causes the pump to use the given cache for history pages.
+func (pump *Pump) SetPageCache(c *PageCache) {
+	pump.pageCache = c
+	if c != nil {
+		c.start(fmt.Sprintf("%X", shortPacket[1:4]))
+	}
+}
+
+// lookup returns the cached contents of page j from the previous session.
+// Page 0 is never returned, since it may have been incomplete.
+func (c *PageCache) lookup(j int) []byte {
+	if j <= 0 {
+		return nil
+	}
+	return c.prev[j]
+}
+
+// match returns the number of times the pages have rolled,
+// if the given page has the same contents as exactly one complete page
+// from the previous session, or -1 otherwise.
+func (c *PageCache) match(page int, data []byte) int {
+	offset := -1
+	for j, prev := range c.prev {
+		if j == 0 || j > page || !bytes.Equal(prev, data) {
+			continue
+		}
+		if offset >= 0 {
+			// Identical pages leave the offset ambiguous.
+			return -1
+		}
+		offset = page - j
+	}
+	return offset
+}
+
+// get returns the contents of the given page, from the cache if possible
+// or else by calling fetch.
+func (c *PageCache) get(page int, fetch func(int) []byte) []byte {
+	if page != 0 && c.offset >= 0 {
+		data := c.lookup(page - c.offset)
+		if data != nil {
+			c.Pages[page] = data
+			return data
+		}
+	}
+	data := fetch(page)
+	if data == nil {
+		return nil
+	}
+	if page != 0 && c.offset < 0 {
+		c.offset = c.match(page, data)
+		if c.offset >= 0 {
+			log.Printf("history pages have rolled %d times; using cached pages after page %d", c.offset, page)
+		}
+	}
+	c.Pages[page] = data
+	return data
+}
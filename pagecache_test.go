@@ -0,0 +1,121 @@
+package medtronic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ecc1/medtronic/packet"
+)
+
+// testPage returns the contents of a history page filled with the given byte.
+func testPage(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 1022)
+}
+
+func TestPageCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pagecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+	cases := []struct {
+		pages   []byte // contents of each page on the pump
+		fetched int    // number of pages expected to be downloaded
+	}{
+		// Empty cache: every page is downloaded.
+		{[]byte{'a', 'B', 'C', 'D'}, 4},
+		// Unchanged except for page 0.
+		{[]byte{'b', 'B', 'C', 'D'}, 2},
+		// Pages have rolled once; the completed page 'A' no longer matches
+		// the partial page 'b' that was cached.
+		{[]byte{'e', 'A', 'B', 'C', 'D'}, 3},
+		// Pages have rolled twice.
+		{[]byte{'f', 'F', 'E', 'A', 'B', 'C', 'D'}, 4},
+	}
+	for _, c := range cases {
+		cache, err := LoadPageCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.start("123456")
+		fetched := 0
+		fetch := func(page int) []byte {
+			fetched++
+			return testPage(c.pages[page])
+		}
+		for page, b := range c.pages {
+			data := cache.get(page, fetch)
+			if !bytes.Equal(data, testPage(b)) {
+				t.Errorf("%q: page %d == %q..., want %q...", c.pages, page, data[:1], b)
+			}
+		}
+		if fetched != c.fetched {
+			t.Errorf("%q: fetched %d pages, want %d", c.pages, fetched, c.fetched)
+		}
+		err = cache.Save(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// collidingPage returns a page that differs from testPage(b)
+// only in its last two bytes and has the same CRC-16 as other.
+func collidingPage(t *testing.T, b byte, other []byte) []byte {
+	crc := packet.CRC16(other)
+	data := testPage(b)
+	n := len(data)
+	for i := 0; i < 1<<16; i++ {
+		data[n-2], data[n-1] = byte(i>>8), byte(i)
+		if packet.CRC16(data) == crc {
+			return data
+		}
+	}
+	t.Fatalf("no page with CRC %04X found", crc)
+	return nil
+}
+
+func TestPageCacheCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pagecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+	x := testPage('X')
+	y := collidingPage(t, 'Y', x)
+	if bytes.Equal(x, y) || packet.CRC16(x) != packet.CRC16(y) {
+		t.Fatal("pages do not collide")
+	}
+	sessions := [][][]byte{
+		{testPage('a'), x, y, testPage('D')},
+		{testPage('b'), x, y, testPage('D')},
+		// Pages have rolled once.
+		{testPage('c'), testPage('B'), x, y, testPage('D')},
+		{testPage('d'), testPage('B'), x, y, testPage('D')},
+	}
+	for n, pages := range sessions {
+		cache, err := LoadPageCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.start("123456")
+		fetch := func(page int) []byte {
+			return pages[page]
+		}
+		for page, want := range pages {
+			data := cache.get(page, fetch)
+			if !bytes.Equal(data, want) {
+				t.Errorf("session %d: page %d has the wrong contents", n, page)
+			}
+		}
+		err = cache.Save(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
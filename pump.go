@@ -55,6 +55,9 @@ type Pump struct {
 	retries int
 	rssi    int
 	err     error
+
+	// Optional cache of history pages.
+	pageCache *PageCache
 }
 
 // Open opens radio communication with a pump.
@@ -70,7 +73,7 @@ func Open() *Pump {
 		return pump
 	}
 	log.Printf("connected to %s radio on %s", r.Name(), r.Device())
-	precomputePackets()
+	precomputePackets(PumpAddress())
 	freq := getFrequency()
 	log.Printf("setting frequency to %s", radio.MegaHertz(freq))
 	r.Init(freq)
@@ -78,6 +81,19 @@ func Open() *Pump {
 	return pump
 }
 
+// SelectPump directs subsequent commands to the pump with the given ID
+// rather than the one specified by the MEDTRONIC_PUMP_ID environment variable.
+func (pump *Pump) SelectPump(id string) {
+	addr, err := DeviceAddress(id)
+	if err != nil {
+		pump.SetError(err)
+		return
+	}
+	precomputePackets(addr)
+	// Forget the previous pump's family.
+	pump.family = 0
+}
+
 // Close closes communication with the pump.
 func (pump *Pump) Close() {
 	r := pump.Radio
@@ -7,33 +7,59 @@ import (
 	"time"
 )
 
-// findHistory retrieves history records from the pump
-// until it encounters one that satisfies the given predicate,
-// in which case that record will be the final element of the result.
-// If the predicate is never satisfied, the entire pump history is returned.
-// The two cases can be distinguished by checking whether
-// the final element of the result satisfies the predicate.
-// The records are retrieved and returned in reverse chronological order.
-func (pump *Pump) findHistory(check func(HistoryRecord) bool) History {
+// ScanHistory calls fn with each history record, in reverse chronological
+// order, as each page is downloaded and decoded, until fn returns false
+// or the entire pump history has been scanned.
+// If an error occurs, the pump's error state is set after fn has been called
+// with all the records that were successfully decoded before the error.
+func (pump *Pump) ScanHistory(fn func(HistoryRecord) bool) {
 	lastPage := pump.LastHistoryPage()
 	if pump.Error() != nil {
-		return nil
+		return
 	}
 	family := pump.Family()
-	var results History
-	for page := 0; page <= lastPage && pump.Error() == nil; page++ {
+	getPage := func(page int) ([]byte, error) {
 		data := pump.HistoryPage(page)
-		records, err := DecodeHistory(data, family)
+		return data, pump.Error()
+	}
+	err := scanHistoryPages(lastPage, family, getPage, fn)
+	pump.SetError(err)
+}
+
+// scanHistoryPages implements ScanHistory using the given function
+// to retrieve history pages.
+func scanHistoryPages(lastPage int, family Family, getPage func(int) ([]byte, error), fn func(HistoryRecord) bool) error {
+	for page := 0; page <= lastPage; page++ {
+		data, err := getPage(page)
 		if err != nil {
-			pump.SetError(err)
+			return err
 		}
-		for i, r := range records {
-			if check(r) {
-				return append(results, records[:i+1]...)
+		records, err := DecodeHistory(data, family)
+		for _, r := range records {
+			if !fn(r) {
+				return nil
 			}
 		}
-		results = append(results, records...)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// findHistory retrieves history records from the pump
+// until it encounters one that satisfies the given predicate,
+// in which case that record will be the final element of the result.
+// If the predicate is never satisfied, the entire pump history is returned.
+// The two cases can be distinguished by checking whether
+// the final element of the result satisfies the predicate.
+// The records are retrieved and returned in reverse chronological order.
+func (pump *Pump) findHistory(check func(HistoryRecord) bool) History {
+	var results History
+	pump.ScanHistory(func(r HistoryRecord) bool {
+		results = append(results, r)
+		return !check(r)
+	})
 	return results
 }
 
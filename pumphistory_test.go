@@ -2,6 +2,8 @@ package medtronic
 
 import (
 	"encoding/base64"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -108,3 +110,64 @@ func findID(records History, id []byte) (History, bool) {
 	}
 	return findRecords(records, check)
 }
+
+// testPages splits records (in reverse chronological order)
+// into history pages of n records each.
+func testPages(records History, n int) [][]byte {
+	var pages [][]byte
+	for i := 0; i < len(records); i += n {
+		j := i + n
+		if j > len(records) {
+			j = len(records)
+		}
+		var page []byte
+		// Records within a page are in chronological order.
+		for k := j - 1; k >= i; k-- {
+			page = append(page, records[k].Data...)
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func TestScanHistory(t *testing.T) {
+	records := setupPumpHistory()
+	pages := testPages(records, 50)
+	pageErr := errors.New("page error")
+	cases := []struct {
+		limit   int // stop after this many records (0 for no limit)
+		badPage int // page that fails to download (-1 for none)
+		count   int
+		err     error
+	}{
+		{0, -1, len(records), nil},
+		{75, -1, 75, nil},
+		{0, 2, 100, pageErr},
+		{0, 0, 0, pageErr},
+	}
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			getPage := func(page int) ([]byte, error) {
+				if page == c.badPage {
+					return nil, pageErr
+				}
+				return pages[page], nil
+			}
+			var results History
+			err := scanHistoryPages(len(pages)-1, 23, getPage, func(r HistoryRecord) bool {
+				results = append(results, r)
+				return len(results) != c.limit
+			})
+			if err != c.err {
+				t.Errorf("scanHistoryPages returned error %v, want %v", err, c.err)
+			}
+			if len(results) != c.count {
+				t.Errorf("scanHistoryPages yielded %d records, want %d", len(results), c.count)
+				return
+			}
+			if c.count != 0 && !reflect.DeepEqual(results, records[:c.count]) {
+				t.Errorf("scanHistoryPages yielded records out of order")
+			}
+		})
+	}
+}
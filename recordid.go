@@ -0,0 +1,163 @@
+package medtronic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// RecordID identifies a history record independently of its position
+// in the pump's history pages. It consists of the record's timestamp
+// (in the pump's local time), a hash of its contents, and its ordinal
+// among earlier records with the same timestamp and contents.
+// Records without a timestamp use that of the closest earlier record that has one.
+type RecordID string
+
+const (
+	recordIDTimeLayout = "20060102T150405"
+
+	// Timestamp used in IDs of records with no earlier timestamp.
+	noRecordTime = "00000000T000000"
+)
+
+var recordIDPattern = regexp.MustCompile(`^([0-9]{8}T[0-9]{6})-([0-9a-f]{12})-([0-9]+)$`)
+
+// ParseRecordID checks whether s is a valid record ID.
+func ParseRecordID(s string) (RecordID, error) {
+	if !recordIDPattern.MatchString(s) {
+		return "", fmt.Errorf("invalid record ID %q", s)
+	}
+	return RecordID(s), nil
+}
+
+// timestamp returns the timestamp part of a record ID.
+func (id RecordID) timestamp() string {
+	m := recordIDPattern.FindStringSubmatch(string(id))
+	if m == nil {
+		return noRecordTime
+	}
+	return m[1]
+}
+
+// recordIDTime returns the timestamp used in record IDs for r,
+// or the empty string if r has none.
+func recordIDTime(r HistoryRecord) string {
+	t := r.Time
+	if !r.PumpTime.IsZero() {
+		t = r.PumpTime
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(recordIDTimeLayout)
+}
+
+// recordHash returns the hash of a record's contents used in record IDs.
+func recordHash(r HistoryRecord) string {
+	sum := sha256.Sum256(r.Data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// recordIDs computes IDs for records in chronological order.
+type recordIDs struct {
+	anchor string
+	counts map[string]int
+}
+
+func newRecordIDs() *recordIDs {
+	return &recordIDs{anchor: noRecordTime, counts: make(map[string]int)}
+}
+
+func (s *recordIDs) next(r HistoryRecord) RecordID {
+	ts := recordIDTime(r)
+	if ts == "" {
+		ts = s.anchor
+	} else if !isDailyTotal(r) {
+		s.anchor = ts
+	}
+	key := ts + "-" + recordHash(r)
+	n := s.counts[key]
+	s.counts[key] = n + 1
+	return RecordID(key + "-" + strconv.Itoa(n))
+}
+
+// chronologicalIDs returns the IDs of records in chronological order.
+func chronologicalIDs(records History) []RecordID {
+	ids := make([]RecordID, len(records))
+	s := newRecordIDs()
+	for i, r := range records {
+		ids[i] = s.next(r)
+	}
+	return ids
+}
+
+// RecordIDs returns the IDs of history records (in reverse chronological order).
+// The ID of a record depends on the records before it,
+// so the results are reliable only for records that are preceded
+// by at least one record with an earlier timestamp.
+// In particular, the IDs of records returned by HistoryFrom or History
+// (which omit the older records) are not safe to use as resume points;
+// use the IDs returned by HistoryFromID instead.
+func RecordIDs(records History) []RecordID {
+	ids := make([]RecordID, len(records))
+	s := newRecordIDs()
+	for i := len(records) - 1; i >= 0; i-- {
+		ids[i] = s.next(records[i])
+	}
+	return ids
+}
+
+// HistoryFromID returns the history records since the record with the given ID,
+// their IDs, and a bool indicating whether it was found. If the record
+// was not found, the result will contain the entire pump history.
+func (pump *Pump) HistoryFromID(id RecordID) (History, []RecordID, bool) {
+	results, ids, found := findFromID(id, pump.ScanHistory)
+	if pump.Error() != nil {
+		return results, ids, false
+	}
+	if found {
+		log.Printf("stopping pump history scan at record %s", id)
+	}
+	return results, ids, found
+}
+
+// findFromID uses the given scan function to retrieve history records
+// until one older than the given ID is found,
+// so that the IDs of the records since then can be computed.
+func findFromID(id RecordID, scan func(func(HistoryRecord) bool)) (History, []RecordID, bool) {
+	cutoff := id.timestamp()
+	var results History
+	searched := false
+	scan(func(r HistoryRecord) bool {
+		results = append(results, r)
+		if searched || isDailyTotal(r) {
+			return true
+		}
+		ts := recordIDTime(r)
+		if ts == "" || ts >= cutoff {
+			return true
+		}
+		// The record's ID can be computed now that an earlier record has been seen.
+		// If it is not found, retrieve the entire pump history.
+		searched = true
+		return indexOfID(RecordIDs(results), id) == -1
+	})
+	ids := RecordIDs(results)
+	i := indexOfID(ids, id)
+	if i == -1 {
+		return results, ids, false
+	}
+	return results[:i], ids[:i], true
+}
+
+func indexOfID(ids []RecordID, id RecordID) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
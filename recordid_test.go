@@ -0,0 +1,141 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordIDs(t *testing.T) {
+	bolus := BolusRecord{Programmed: 1000, Amount: 1000}
+	// Records in reverse chronological order.
+	records := History{
+		testDose(UnabsorbedInsulin, "", UnabsorbedBolusHistory{{Bolus: 1000}}),
+		testDose(Bolus, "2020-03-10T12:00:00", bolus),
+		testDose(UnabsorbedInsulin, "", UnabsorbedBolusHistory{{Bolus: 1000}}),
+		testDose(Bolus, "2020-03-10T12:00:00", bolus),
+		testRecord(Rewind, "2020-03-10T11:00:00"),
+	}
+	for i := range records {
+		data, err := EncodeHistoryRecord(records[i], 23)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[i].Data = data
+	}
+	ids := RecordIDs(records)
+	seen := make(map[RecordID]bool)
+	for i, id := range ids {
+		if _, err := ParseRecordID(string(id)); err != nil {
+			t.Error(err)
+		}
+		if seen[id] {
+			t.Errorf("record %d has duplicate ID %s", i, id)
+		}
+		seen[id] = true
+	}
+	// Identical records differ only in their ordinals.
+	if ids[0][:len(ids[0])-1] != ids[2][:len(ids[2])-1] || ids[1][:len(ids[1])-1] != ids[3][:len(ids[3])-1] {
+		t.Errorf("IDs of identical records %v differ by more than their ordinals", ids)
+	}
+	// Records without timestamps use the previous timestamp.
+	if ids[2].timestamp() != "20200310T120000" {
+		t.Errorf("ID %s has timestamp %s, want 20200310T120000", ids[2], ids[2].timestamp())
+	}
+	for _, s := range []string{"", "ewgAgAsZFBYjAA==", "20200310T120000-abc-0"} {
+		if _, err := ParseRecordID(s); err == nil {
+			t.Errorf("ParseRecordID(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRecordIDsStable(t *testing.T) {
+	cases := []testCase{
+		{"pump-records", 523, 0},
+		{"ps2", 522, 2},
+		{"ps2", 551, 1},
+		{"ps2", 554, 1},
+	}
+	for _, c := range cases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			records, err := decodeFromData(testFile+".json", testPumpFamily(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := RecordIDs(records)
+			seen := make(map[RecordID]bool)
+			for _, id := range ids {
+				if seen[id] {
+					t.Errorf("duplicate ID %s", id)
+				}
+				seen[id] = true
+			}
+			// Adding newer records does not change the IDs of older ones.
+			n := len(records) / 3
+			if older := RecordIDs(records[n:]); !reflect.DeepEqual(older, ids[n:]) {
+				t.Errorf("IDs changed when newer records were added")
+			}
+			// Losing older records (when pump history wraps around)
+			// does not change the IDs of records after the first timestamped one.
+			m := len(records) - n
+			newer := RecordIDs(records[:m])
+			i := m - 1
+			for i >= 0 && (records[i].Time.IsZero() || isDailyTotal(records[i])) {
+				i--
+			}
+			if !reflect.DeepEqual(newer[:i], ids[:i]) {
+				t.Errorf("IDs changed when older records were removed")
+			}
+		})
+	}
+}
+
+func TestFindFromID(t *testing.T) {
+	records := setupPumpHistory()
+	ids := RecordIDs(records)
+	scan := func(fn func(HistoryRecord) bool) {
+		for _, r := range records {
+			if !fn(r) {
+				return
+			}
+		}
+	}
+	for _, index := range []int{0, 8, 100, 296} {
+		results, resultIDs, found := findFromID(ids[index], scan)
+		if !found {
+			t.Errorf("findFromID(%s) not found", ids[index])
+			continue
+		}
+		if !reflect.DeepEqual(results, records[:index]) || !reflect.DeepEqual(resultIDs, ids[:index]) {
+			t.Errorf("findFromID(%s) returned %d records, want %d", ids[index], len(results), index)
+		}
+	}
+	missing := RecordID("20200224T120000-000000000000-0")
+	results, _, found := findFromID(missing, scan)
+	if found || len(results) != len(records) {
+		t.Errorf("findFromID(%s) = %d records, %v; want %d records, false", missing, len(results), found, len(records))
+	}
+}
+
+func TestHistoryStoreIDs(t *testing.T) {
+	records := setupPumpHistory()
+	ReverseHistory(records)
+	// Duplicate the most recent record, as if two identical records
+	// had been written in the same second.
+	last := records[len(records)-1]
+	records = append(records, last)
+	s := &HistoryStore{seen: make(map[RecordID]bool)}
+	ids := chronologicalIDs(records)
+	n := len(records) - 2
+	for i, r := range records[:n] {
+		s.remember(r, ids[i])
+	}
+	if s.LastID() != ids[n-1] {
+		t.Errorf("LastID() = %s, want %s", s.LastID(), ids[n-1])
+	}
+	for i := n; i < len(records); i++ {
+		if s.seen[ids[i]] {
+			t.Errorf("record %d with ID %s is already in the store", i, ids[i])
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package medtronic
 
 import (
+	"fmt"
 	"time"
 )
 
+const (
+	minInsulinAction = 2 * time.Hour
+	maxInsulinAction = 8 * time.Hour
+)
+
 // SettingsInfo represents the pump's settings.
 type SettingsInfo struct {
 	AutoOff              time.Duration
@@ -74,6 +80,29 @@ func (pump *Pump) Settings() SettingsInfo {
 	return i
 }
 
+func encodeInsulinAction(d time.Duration, family Family) (byte, error) {
+	if family <= 12 {
+		// Older pumps specify the insulin type instead.
+		switch d {
+		case 6 * time.Hour:
+			// Fast-acting insulin type.
+			return 0, nil
+		case 8 * time.Hour:
+			// Regular insulin type.
+			return 1, nil
+		default:
+			return 0, fmt.Errorf("insulin action (%v) must be 6h or 8h for model x12 pumps", d)
+		}
+	}
+	if d%time.Hour != 0 {
+		return 0, fmt.Errorf("insulin action (%v) is not a whole number of hours", d)
+	}
+	if d < minInsulinAction || maxInsulinAction < d {
+		return 0, fmt.Errorf("insulin action (%v) is not between %v and %v", d, minInsulinAction, maxInsulinAction)
+	}
+	return byte(d / time.Hour), nil
+}
+
 func insulinConcentration(data []byte) (int, error) {
 	switch data[10] {
 	case 0:
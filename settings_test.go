@@ -110,3 +110,38 @@ func TestSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeInsulinAction(t *testing.T) {
+	cases := []struct {
+		action time.Duration
+		family Family
+		n      byte
+		ok     bool
+	}{
+		{6 * time.Hour, 12, 0, true},
+		{8 * time.Hour, 12, 1, true},
+		{4 * time.Hour, 12, 0, false},
+		{4 * time.Hour, 22, 4, true},
+		{3 * time.Hour, 23, 3, true},
+		{90 * time.Minute, 23, 0, false},
+		{9 * time.Hour, 23, 0, false},
+	}
+	for _, c := range cases {
+		t.Run("", func(t *testing.T) {
+			n, err := encodeInsulinAction(c.action, c.family)
+			if !c.ok {
+				if err == nil {
+					t.Errorf("encodeInsulinAction(%v, %d) did not raise error", c.action, c.family)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("encodeInsulinAction(%v, %d) raised error (%v)", c.action, c.family, err)
+				return
+			}
+			if n != c.n {
+				t.Errorf("encodeInsulinAction(%v, %d) == %d, want %d", c.action, c.family, n, c.n)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package medtronic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Names of the settings tracked by SettingsHistory,
+// as used in SettingsChange values.
+const (
+	maxBasalSetting        = "max basal"
+	maxBolusSetting        = "max bolus"
+	autoOffSetting         = "auto-off"
+	tempBasalTypeSetting   = "temp basal type"
+	basalPatternSetting    = "selected basal pattern"
+	insulinActionSetting   = "insulin action"
+	carbUnitsSetting       = "carb units"
+	carbRatioSetting       = "carb ratio"
+	sensitivitySetting     = "sensitivity"
+	targetSetting          = "target"
+	unknownSettingValue    = "unknown"
+	clearedSettingsSetting = "settings"
+)
+
+var trackedSettings = []string{
+	maxBasalSetting,
+	maxBolusSetting,
+	autoOffSetting,
+	tempBasalTypeSetting,
+	basalPatternSetting,
+	insulinActionSetting,
+	carbUnitsSetting,
+	basalScheduleName(0),
+	basalScheduleName(1),
+	basalScheduleName(2),
+	carbRatioSetting,
+	sensitivitySetting,
+	targetSetting,
+}
+
+// A settingsUpdate changes one setting.
+// Revert restores the previous value, or is nil if it was not recorded.
+type settingsUpdate struct {
+	setting string
+	apply   func(*Backup)
+	revert  func(*Backup)
+}
+
+// A settingsEvent is the set of updates made by a single history record,
+// along with the resulting settings.
+type settingsEvent struct {
+	time    time.Time
+	source  HistoryRecordType
+	clear   bool
+	updates []settingsUpdate
+	state   settingsState
+}
+
+// settingsState holds the pump's settings at some point in the replay.
+// Settings that cannot be determined from the history are not in known.
+type settingsState struct {
+	settings Backup
+	known    map[string]bool
+}
+
+func (s settingsState) clone() settingsState {
+	known := make(map[string]bool, len(s.known))
+	for k, v := range s.known {
+		known[k] = v
+	}
+	return settingsState{settings: s.settings, known: known}
+}
+
+// SettingsHistory is a time-indexed history of the pump's settings,
+// reconstructed from the settings changes recorded in its history.
+type SettingsHistory struct {
+	initial settingsState
+	events  []settingsEvent
+}
+
+// SettingsSnapshot represents the pump's settings at a given time.
+// Settings that cannot be determined are listed in Unknown
+// and have zero values in Settings.
+type SettingsSnapshot struct {
+	Time     time.Time
+	Settings Backup
+	Unknown  []string `json:",omitempty"`
+}
+
+// SettingsAuditEntry records the settings changed by a history record.
+// The pump does not record whether a change was made using its buttons
+// or remotely, so Source is the type of the record that made the change.
+type SettingsAuditEntry struct {
+	Time    time.Time
+	Source  string
+	Changes []SettingsChange
+}
+
+// ReplaySettings reconstructs the history of the pump's settings
+// from history records (in reverse chronological order).
+// If current is not the zero Backup, it is used to fill in settings
+// that were not changed after the time in question.
+// Records that change the basal schedule are assumed to apply
+// to the basal pattern that was selected at the time.
+// The pump does not record which pattern was selected before
+// a ChangeBasalPattern record, so changes made before the first one
+// are assumed to apply to the standard schedule.
+func ReplaySettings(records History, current Backup) *SettingsHistory {
+	pattern := current.Settings.SelectedPattern
+	for _, r := range records {
+		if r.Type() == ChangeBasalPattern {
+			pattern = 0
+			break
+		}
+	}
+	events := settingsEvents(records, pattern)
+	// Work backward from the current settings, reverting each change.
+	state := settingsState{settings: current, known: make(map[string]bool)}
+	if current.Version != 0 {
+		for _, name := range trackedSettings {
+			state.known[name] = true
+		}
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		events[i].state = state
+		state = state.revert(events[i])
+	}
+	h := &SettingsHistory{initial: state, events: events}
+	// Then work forward, filling in settings whose new values
+	// are recorded but cannot be determined from the current settings.
+	for i := range events {
+		state = state.apply(events[i])
+		events[i].state = events[i].state.merge(state)
+		state = events[i].state
+	}
+	return h
+}
+
+// revert returns the settings before the given change.
+func (s settingsState) revert(e settingsEvent) settingsState {
+	if e.clear {
+		return settingsState{known: make(map[string]bool)}
+	}
+	s = s.clone()
+	for _, u := range e.updates {
+		if u.revert == nil {
+			copySetting(u.setting, &s.settings, &Backup{})
+			s.known[u.setting] = false
+			continue
+		}
+		u.revert(&s.settings)
+		s.known[u.setting] = true
+	}
+	return s
+}
+
+// apply returns the settings after the given change.
+func (s settingsState) apply(e settingsEvent) settingsState {
+	if e.clear {
+		s = settingsState{known: make(map[string]bool)}
+	} else {
+		s = s.clone()
+	}
+	for _, u := range e.updates {
+		u.apply(&s.settings)
+		s.known[u.setting] = true
+	}
+	return s
+}
+
+// merge returns a copy of s with any settings it lacks taken from t.
+func (s settingsState) merge(t settingsState) settingsState {
+	s = s.clone()
+	for _, name := range trackedSettings {
+		if !s.known[name] && t.known[name] {
+			copySetting(name, &s.settings, &t.settings)
+			s.known[name] = true
+		}
+	}
+	return s
+}
+
+func copySetting(name string, dst, src *Backup) {
+	switch name {
+	case maxBasalSetting:
+		dst.Settings.MaxBasal = src.Settings.MaxBasal
+	case maxBolusSetting:
+		dst.Settings.MaxBolus = src.Settings.MaxBolus
+	case autoOffSetting:
+		dst.Settings.AutoOff = src.Settings.AutoOff
+	case tempBasalTypeSetting:
+		dst.Settings.TempBasalType = src.Settings.TempBasalType
+	case basalPatternSetting:
+		dst.Settings.SelectedPattern = src.Settings.SelectedPattern
+	case insulinActionSetting:
+		dst.Settings.InsulinAction = src.Settings.InsulinAction
+	case carbUnitsSetting:
+		dst.CarbUnits = src.CarbUnits
+	case carbRatioSetting:
+		dst.CarbRatios = src.CarbRatios
+	case sensitivitySetting:
+		dst.Sensitivities = src.Sensitivities
+	case targetSetting:
+		dst.Targets = src.Targets
+	default:
+		for i := range basalPatternNames {
+			if name == basalScheduleName(i) {
+				*dst.basalSchedule(i) = *src.basalSchedule(i)
+			}
+		}
+	}
+}
+
+// settingsEvents returns the settings changes in the given history records,
+// in chronological order, starting with the given basal pattern selected.
+func settingsEvents(records History, pattern int) []settingsEvent {
+	history := make(History, len(records))
+	copy(history, records)
+	ReverseHistory(history)
+	var events []settingsEvent
+	var basalBefore BasalRateSchedule
+	haveBefore := false
+	for _, r := range history {
+		if r.Time.IsZero() {
+			continue
+		}
+		e := settingsEvent{time: r.Time, source: r.Type()}
+		switch r.Type() {
+		case MaxBolus:
+			v := r.Info.(Insulin)
+			e.updates = []settingsUpdate{{
+				setting: maxBolusSetting,
+				apply:   func(b *Backup) { b.Settings.MaxBolus = v },
+			}}
+		case MaxBasal:
+			v := r.Info.(Insulin)
+			e.updates = []settingsUpdate{{
+				setting: maxBasalSetting,
+				apply:   func(b *Backup) { b.Settings.MaxBasal = v },
+			}}
+		case SetAutoOff:
+			v := time.Duration(r.Info.(Duration))
+			e.updates = []settingsUpdate{{
+				setting: autoOffSetting,
+				apply:   func(b *Backup) { b.Settings.AutoOff = v },
+			}}
+		case ChangeTempBasalType:
+			v := r.Info.(TempBasalType)
+			// There are only two types, so the previous one is implied.
+			old := Absolute
+			if v == Absolute {
+				old = Percent
+			}
+			e.updates = []settingsUpdate{{
+				setting: tempBasalTypeSetting,
+				apply:   func(b *Backup) { b.Settings.TempBasalType = v },
+				revert:  func(b *Backup) { b.Settings.TempBasalType = old },
+			}}
+		case ChangeCarbUnits:
+			// The low nibble holds the new units and the high nibble the old ones.
+			v := r.Info.(int)
+			units, old := CarbUnitsType(v&0xF), CarbUnitsType(v>>4)
+			e.updates = []settingsUpdate{{
+				setting: carbUnitsSetting,
+				apply:   func(b *Backup) { b.CarbUnits = units },
+				revert:  func(b *Backup) { b.CarbUnits = old },
+			}}
+		case ChangeBasalPattern:
+			pattern = r.Info.(int)
+			v := pattern
+			e.updates = []settingsUpdate{{
+				setting: basalPatternSetting,
+				apply:   func(b *Backup) { b.Settings.SelectedPattern = v },
+			}}
+		case BasalProfileBefore:
+			basalBefore = r.Info.(BasalRateSchedule)
+			haveBefore = true
+			continue
+		case BasalProfileAfter:
+			e.updates = []settingsUpdate{basalUpdate(pattern, r.Info.(BasalRateSchedule), basalBefore, haveBefore)}
+			haveBefore = false
+		case BolusWizardSetup:
+			e.updates = wizardUpdates(r.Info.(BolusWizardSetupRecord))
+		case Unknown2E:
+			// x12 pumps do not record the insulin action time.
+			e.updates = wizardScheduleUpdates(r.Info.(BolusWizardSetupRecord))
+		case ClearSettings:
+			e.clear = true
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func basalUpdate(pattern int, after, before BasalRateSchedule, haveBefore bool) settingsUpdate {
+	u := settingsUpdate{
+		setting: basalScheduleName(pattern),
+		apply:   func(b *Backup) { *b.basalSchedule(pattern) = after },
+	}
+	if haveBefore {
+		u.revert = func(b *Backup) { *b.basalSchedule(pattern) = before }
+	}
+	return u
+}
+
+func wizardUpdates(setup BolusWizardSetupRecord) []settingsUpdate {
+	before, after := setup.Before, setup.After
+	return append(wizardScheduleUpdates(setup), settingsUpdate{
+		setting: insulinActionSetting,
+		apply:   func(b *Backup) { b.Settings.InsulinAction = time.Duration(after.InsulinAction) },
+		revert:  func(b *Backup) { b.Settings.InsulinAction = time.Duration(before.InsulinAction) },
+	})
+}
+
+func wizardScheduleUpdates(setup BolusWizardSetupRecord) []settingsUpdate {
+	before, after := setup.Before, setup.After
+	return []settingsUpdate{
+		{
+			setting: carbRatioSetting,
+			apply:   func(b *Backup) { b.CarbRatios = after.Ratios },
+			revert:  func(b *Backup) { b.CarbRatios = before.Ratios },
+		},
+		{
+			setting: sensitivitySetting,
+			apply:   func(b *Backup) { b.Sensitivities = after.Sensitivities },
+			revert:  func(b *Backup) { b.Sensitivities = before.Sensitivities },
+		},
+		{
+			setting: targetSetting,
+			apply:   func(b *Backup) { b.Targets = after.Targets },
+			revert:  func(b *Backup) { b.Targets = before.Targets },
+		},
+	}
+}
+
+func (b *Backup) basalSchedule(pattern int) *BasalRateSchedule {
+	switch pattern {
+	case 1:
+		return &b.BasalPatternA
+	case 2:
+		return &b.BasalPatternB
+	default:
+		return &b.BasalRates
+	}
+}
+
+// At returns the pump's settings at the given time.
+// The history is assumed to be in chronological order,
+// so timestamps should be normalized (see NormalizeHistory)
+// if the pump's clock was changed.
+func (h *SettingsHistory) At(t time.Time) SettingsSnapshot {
+	state := h.initial
+	for _, e := range h.events {
+		if e.time.After(t) {
+			break
+		}
+		state = e.state
+	}
+	snap := SettingsSnapshot{Time: t, Settings: state.settings}
+	for _, name := range trackedSettings {
+		if !state.known[name] {
+			snap.Unknown = append(snap.Unknown, name)
+		}
+	}
+	sort.Strings(snap.Unknown)
+	return snap
+}
+
+// AuditLog returns the settings changes in the order they were made.
+// Old values that cannot be determined from the history are reported as "unknown".
+func (h *SettingsHistory) AuditLog() ([]SettingsAuditEntry, error) {
+	var log []SettingsAuditEntry
+	prev := h.initial
+	for _, e := range h.events {
+		entry := SettingsAuditEntry{Time: e.time, Source: e.source.String()}
+		if e.clear {
+			entry.Changes = append(entry.Changes, SettingsChange{Setting: clearedSettingsSetting, Old: "set", New: "cleared"})
+			prev = settingsState{known: make(map[string]bool)}
+		}
+		for _, u := range e.updates {
+			changes, err := diffSetting(u.setting, prev, e.state)
+			if err != nil {
+				return log, err
+			}
+			entry.Changes = append(entry.Changes, changes...)
+		}
+		if len(entry.Changes) != 0 {
+			log = append(log, entry)
+		}
+		prev = e.state
+	}
+	return log, nil
+}
+
+// diffSetting compares the value of a setting in two states.
+func diffSetting(name string, old, current settingsState) ([]SettingsChange, error) {
+	if v, ok := settingValue(name, current.settings); ok {
+		w := unknownSettingValue
+		if old.known[name] {
+			w, _ = settingValue(name, old.settings)
+		}
+		return diffValue(name, w, v), nil
+	}
+	currentSegments, err := settingSegments(name, current.settings)
+	if err != nil {
+		return nil, err
+	}
+	if !old.known[name] {
+		changes := diffSchedule(name, nil, currentSegments)
+		for i := range changes {
+			changes[i].Old = unknownSettingValue
+		}
+		return changes, nil
+	}
+	oldSegments, err := settingSegments(name, old.settings)
+	if err != nil {
+		return nil, err
+	}
+	return diffSchedule(name, oldSegments, currentSegments), nil
+}
+
+// settingValue returns the value of a setting that is not a schedule.
+func settingValue(name string, b Backup) (string, bool) {
+	s := b.Settings
+	switch name {
+	case maxBasalSetting:
+		return formatInsulin(s.MaxBasal), true
+	case maxBolusSetting:
+		return formatInsulin(s.MaxBolus), true
+	case autoOffSetting:
+		return s.AutoOff.String(), true
+	case tempBasalTypeSetting:
+		return s.TempBasalType.String(), true
+	case basalPatternSetting:
+		return basalPatternName(s.SelectedPattern), true
+	case insulinActionSetting:
+		return s.InsulinAction.String(), true
+	case carbUnitsSetting:
+		return b.CarbUnits.String(), true
+	}
+	return "", false
+}
+
+func settingSegments(name string, b Backup) ([]segment, error) {
+	switch name {
+	case carbRatioSetting:
+		return carbRatioSegments(b.CarbRatios), nil
+	case sensitivitySetting:
+		return sensitivitySegments(b.Sensitivities), nil
+	case targetSetting:
+		return targetSegments(b.Targets), nil
+	}
+	for i, s := range b.basalSchedules() {
+		if name == basalScheduleName(i) {
+			return basalSegments(s), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown setting %q", name)
+}
@@ -0,0 +1,173 @@
+package medtronic
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReplaySettings(t *testing.T) {
+	basal := func(rates ...Insulin) BasalRateSchedule {
+		var s BasalRateSchedule
+		for i, r := range rates {
+			s = append(s, BasalRate{Start: TimeOfDay(12 * i * int(time.Hour)), Rate: r})
+		}
+		return s
+	}
+	ratios := func(r Ratio) CarbRatioSchedule {
+		return CarbRatioSchedule{{Ratio: r, Units: Grams}}
+	}
+	// Records in reverse chronological order.
+	records := History{
+		testDose(ChangeCarbUnits, "2020-03-12T09:00:00", 0x12),
+		testDose(BasalProfileAfter, "2020-03-11T20:00:00", basal(900, 1100)),
+		testDose(BasalProfileBefore, "2020-03-11T20:00:00", basal(1000)),
+		testDose(ChangeBasalPattern, "2020-03-11T19:00:00", 1),
+		testDose(BolusWizardSetup, "2020-03-11T12:00:00", BolusWizardSetupRecord{
+			Before: BolusWizardConfig{Ratios: ratios(100), InsulinAction: Duration(4 * time.Hour)},
+			After:  BolusWizardConfig{Ratios: ratios(120), InsulinAction: Duration(4 * time.Hour)},
+		}),
+		testDose(MaxBolus, "2020-03-10T10:00:00", Insulin(10000)),
+		testRecord(ClearSettings, "2020-03-10T08:00:00"),
+		testDose(MaxBolus, "2020-03-09T10:00:00", Insulin(5000)),
+	}
+	current := Backup{
+		Version:   1,
+		CarbUnits: Exchanges,
+		Settings: SettingsInfo{
+			MaxBolus:        10000,
+			MaxBasal:        3000,
+			InsulinAction:   4 * time.Hour,
+			SelectedPattern: 1,
+		},
+		BasalRates:    basal(1000),
+		BasalPatternA: basal(900, 1100),
+		CarbRatios:    ratios(120),
+	}
+	h := ReplaySettings(records, current)
+
+	allBut := func(known ...string) []string {
+		var v []string
+		for _, name := range trackedSettings {
+			found := false
+			for _, k := range known {
+				found = found || k == name
+			}
+			if !found {
+				v = append(v, name)
+			}
+		}
+		sort.Strings(v)
+		return v
+	}
+	cases := []struct {
+		time      string
+		maxBolus  Insulin
+		maxBasal  Insulin
+		pattern   int
+		patternA  BasalRateSchedule
+		ratios    CarbRatioSchedule
+		carbUnits CarbUnitsType
+		unknown   []string
+	}{
+		{"2020-03-09T12:00:00", 5000, 0, 0, nil, nil, 0, allBut(maxBolusSetting)},
+		{"2020-03-10T09:00:00", 0, 3000, 0, basal(1000), ratios(100), Grams, []string{maxBolusSetting, basalPatternSetting}},
+		{"2020-03-11T00:00:00", 10000, 3000, 0, basal(1000), ratios(100), Grams, []string{basalPatternSetting}},
+		{"2020-03-11T13:00:00", 10000, 3000, 0, basal(1000), ratios(120), Grams, []string{basalPatternSetting}},
+		{"2020-03-12T00:00:00", 10000, 3000, 1, basal(900, 1100), ratios(120), Grams, nil},
+		{"2020-03-12T10:00:00", 10000, 3000, 1, basal(900, 1100), ratios(120), Exchanges, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.time, func(t *testing.T) {
+			snap := h.At(parseTime(c.time))
+			s := snap.Settings
+			if s.Settings.MaxBolus != c.maxBolus {
+				t.Errorf("max bolus = %v, want %v", s.Settings.MaxBolus, c.maxBolus)
+			}
+			if s.Settings.MaxBasal != c.maxBasal {
+				t.Errorf("max basal = %v, want %v", s.Settings.MaxBasal, c.maxBasal)
+			}
+			if s.Settings.SelectedPattern != c.pattern {
+				t.Errorf("selected pattern = %d, want %d", s.Settings.SelectedPattern, c.pattern)
+			}
+			if !reflect.DeepEqual(s.BasalPatternA, c.patternA) {
+				t.Errorf("basal pattern A = %v, want %v", s.BasalPatternA, c.patternA)
+			}
+			if !reflect.DeepEqual(s.CarbRatios, c.ratios) {
+				t.Errorf("carb ratios = %v, want %v", s.CarbRatios, c.ratios)
+			}
+			if s.CarbUnits != c.carbUnits {
+				t.Errorf("carb units = %v, want %v", s.CarbUnits, c.carbUnits)
+			}
+			if !reflect.DeepEqual(snap.Unknown, c.unknown) {
+				t.Errorf("unknown settings = %v, want %v", snap.Unknown, c.unknown)
+			}
+		})
+	}
+
+	log, err := h.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit []string
+	for _, e := range log {
+		for _, c := range e.Changes {
+			audit = append(audit, e.Time.Format("2006-01-02T15:04")+" "+e.Source+": "+c.String())
+		}
+	}
+	want := []string{
+		"2020-03-09T10:00 MaxBolus: max bolus unknown → 5.00",
+		"2020-03-10T08:00 ClearSettings: settings set → cleared",
+		"2020-03-10T10:00 MaxBolus: max bolus unknown → 10.00",
+		"2020-03-11T12:00 BolusWizardSetup: 00:00 carb ratio 10.0 g/U → 12.0 g/U",
+		"2020-03-11T19:00 ChangeBasalPattern: selected basal pattern unknown → basal pattern A",
+		"2020-03-11T20:00 BasalProfileAfter: 00:00 basal pattern A 1.00 → 0.90",
+		"2020-03-11T20:00 BasalProfileAfter: 12:00 basal pattern A 1.00 → 1.10",
+		"2020-03-12T09:00 ChangeCarbUnits: carb units Grams → Exchanges",
+	}
+	if !reflect.DeepEqual(audit, want) {
+		t.Errorf("AuditLog() = %q, want %q", audit, want)
+	}
+}
+
+func TestReplaySettingsHistory(t *testing.T) {
+	cases := []testCase{
+		{"ps2", 522, 2},
+		{"ps2", 554, 3},
+		{"ps2", 554, 5},
+	}
+	for _, c := range cases {
+		name := testFileName(c)
+		t.Run(name, func(t *testing.T) {
+			records, err := decodeFromData(name+".json", testPumpFamily(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := ReplaySettings(records, Backup{})
+			log, err := h.AuditLog()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(log) == 0 {
+				t.Fatal("AuditLog() returned no entries")
+			}
+			for i, e := range log {
+				if i > 0 && e.Time.Before(log[i-1].Time) {
+					t.Errorf("audit entry %d is out of order: %+v", i, e)
+				}
+				unknown := h.At(e.Time).Unknown
+				for _, c := range e.Changes {
+					if c.Setting == clearedSettingsSetting {
+						continue
+					}
+					for _, name := range unknown {
+						if name == c.Setting {
+							t.Errorf("%s is unknown after %+v", name, e)
+						}
+					}
+				}
+			}
+		})
+	}
+}
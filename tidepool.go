@@ -0,0 +1,536 @@
+package medtronic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Data types from the Tidepool data model,
+// as accepted by the Tidepool uploader API.
+// Insulin is in units, durations and schedule start times in milliseconds,
+// and glucose values in the units given by the datum.
+
+const (
+	tidepoolTimeLayout       = "2006-01-02T15:04:05.000Z"
+	tidepoolDeviceTimeLayout = "2006-01-02T15:04:05"
+)
+
+var tidepoolScheduleNames = [3]string{"standard", "pattern a", "pattern b"}
+
+// TidepoolDatum is implemented by all the Tidepool data types.
+type TidepoolDatum interface {
+	base() TidepoolBase
+}
+
+// TidepoolData is a sequence of Tidepool data.
+type TidepoolData []TidepoolDatum
+
+// TidepoolBase holds the fields common to all Tidepool data.
+// DeviceTime is the pump's clock time, and ConversionOffset
+// the difference between it and Time if the history was normalized.
+type TidepoolBase struct {
+	Type             string `json:"type"`
+	SubType          string `json:"subType,omitempty"`
+	Time             string `json:"time"`
+	DeviceTime       string `json:"deviceTime"`
+	TimezoneOffset   int    `json:"timezoneOffset"`
+	ConversionOffset int64  `json:"conversionOffset"`
+	DeviceID         string `json:"deviceId"`
+}
+
+func (b TidepoolBase) base() TidepoolBase {
+	return b
+}
+
+// TidepoolBolus represents a normal, square, or dual wave bolus.
+// The expected values are present if the bolus was interrupted.
+type TidepoolBolus struct {
+	TidepoolBase
+	Normal           *float64 `json:"normal,omitempty"`
+	ExpectedNormal   *float64 `json:"expectedNormal,omitempty"`
+	Extended         *float64 `json:"extended,omitempty"`
+	ExpectedExtended *float64 `json:"expectedExtended,omitempty"`
+	Duration         *int64   `json:"duration,omitempty"`
+	ExpectedDuration *int64   `json:"expectedDuration,omitempty"`
+}
+
+// TidepoolBasal represents a scheduled, temp, or suspended basal.
+type TidepoolBasal struct {
+	TidepoolBase
+	DeliveryType string   `json:"deliveryType"`
+	Rate         *float64 `json:"rate,omitempty"`
+	Duration     int64    `json:"duration"`
+}
+
+// TidepoolWizard represents a bolus wizard calculation,
+// along with the bolus that followed it, if any.
+type TidepoolWizard struct {
+	TidepoolBase
+	Units              string              `json:"units"`
+	BGInput            *float64            `json:"bgInput,omitempty"`
+	CarbInput          float64             `json:"carbInput"`
+	InsulinCarbRatio   float64             `json:"insulinCarbRatio"`
+	InsulinSensitivity float64             `json:"insulinSensitivity"`
+	BGTarget           TidepoolBGTarget    `json:"bgTarget"`
+	InsulinOnBoard     float64             `json:"insulinOnBoard"`
+	Recommended        TidepoolRecommended `json:"recommended"`
+	Bolus              *TidepoolBolus      `json:"bolus,omitempty"`
+}
+
+// TidepoolBGTarget represents a glucose target range.
+type TidepoolBGTarget struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// TidepoolRecommended represents the bolus recommended by the wizard.
+type TidepoolRecommended struct {
+	Carb       float64 `json:"carb"`
+	Correction float64 `json:"correction"`
+	Net        float64 `json:"net"`
+}
+
+// TidepoolGlucose represents a meter (smbg) or sensor (cbg) glucose value.
+type TidepoolGlucose struct {
+	TidepoolBase
+	Units string  `json:"units"`
+	Value float64 `json:"value"`
+}
+
+// TidepoolDeviceEvent represents a prime, reservoir change, alarm, or time change.
+type TidepoolDeviceEvent struct {
+	TidepoolBase
+	PrimeTarget string              `json:"primeTarget,omitempty"`
+	Volume      *float64            `json:"volume,omitempty"`
+	AlarmType   string              `json:"alarmType,omitempty"`
+	Change      *TidepoolTimeChange `json:"change,omitempty"`
+}
+
+// TidepoolTimeChange represents a change to the pump's clock.
+type TidepoolTimeChange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Agent string `json:"agent"`
+}
+
+// TidepoolPumpSettings represents the pump's settings and schedules.
+type TidepoolPumpSettings struct {
+	TidepoolBase
+	ActiveSchedule     string                          `json:"activeSchedule"`
+	BasalSchedules     map[string][]TidepoolBasalEntry `json:"basalSchedules"`
+	BGTarget           []TidepoolBGTargetEntry         `json:"bgTarget"`
+	CarbRatio          []TidepoolScheduleEntry         `json:"carbRatio"`
+	InsulinSensitivity []TidepoolScheduleEntry         `json:"insulinSensitivity"`
+	Units              TidepoolUnits                   `json:"units"`
+}
+
+// TidepoolBasalEntry is an entry in a basal schedule.
+type TidepoolBasalEntry struct {
+	Start int64   `json:"start"`
+	Rate  float64 `json:"rate"`
+}
+
+// TidepoolBGTargetEntry is an entry in a glucose target schedule.
+type TidepoolBGTargetEntry struct {
+	Start int64   `json:"start"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+// TidepoolScheduleEntry is an entry in a carb ratio or sensitivity schedule.
+type TidepoolScheduleEntry struct {
+	Start  int64   `json:"start"`
+	Amount float64 `json:"amount"`
+}
+
+// TidepoolUnits gives the units used in pump settings.
+type TidepoolUnits struct {
+	Carb string `json:"carb"`
+	BG   string `json:"bg"`
+}
+
+// TidepoolDeviceID returns the Tidepool device ID for a pump.
+func TidepoolDeviceID(model string, pumpID string) string {
+	return "MedT-" + model + "-" + pumpID
+}
+
+type tidepoolConverter struct {
+	deviceID string
+	// Records with their pump clock times (kept by NormalizeHistory),
+	// in chronological order.
+	clock History
+}
+
+func newTidepoolConverter(records History, deviceID string) tidepoolConverter {
+	c := tidepoolConverter{deviceID: deviceID}
+	for i := len(records) - 1; i >= 0; i-- {
+		if !records[i].PumpTime.IsZero() {
+			c.clock = append(c.clock, records[i])
+		}
+	}
+	return c
+}
+
+// pumpTime returns the pump's clock time at the normalized time t,
+// using the clock offset of the closest preceding record.
+// It returns the zero time if the history was not normalized.
+func (c tidepoolConverter) pumpTime(t time.Time) time.Time {
+	if len(c.clock) == 0 {
+		return time.Time{}
+	}
+	i := sort.Search(len(c.clock), func(i int) bool {
+		return c.clock[i].Time.After(t)
+	})
+	if i > 0 {
+		i--
+	}
+	r := c.clock[i]
+	return r.PumpTime.Add(t.Sub(r.Time))
+}
+
+// normalTime is the inverse of pumpTime, for CGM records,
+// which are timestamped with the pump's clock time.
+func (c tidepoolConverter) normalTime(t time.Time) time.Time {
+	if len(c.clock) == 0 {
+		return t
+	}
+	i := sort.Search(len(c.clock), func(i int) bool {
+		return c.clock[i].PumpTime.After(t)
+	})
+	if i > 0 {
+		i--
+	}
+	r := c.clock[i]
+	return r.Time.Add(t.Sub(r.PumpTime))
+}
+
+func (c tidepoolConverter) base(kind string, subType string, t time.Time, pumpTime time.Time) TidepoolBase {
+	deviceTime := t
+	if !pumpTime.IsZero() {
+		deviceTime = pumpTime
+	}
+	// Normalized times are in UTC, so the time zone is that of the pump clock.
+	_, offset := deviceTime.Zone()
+	return TidepoolBase{
+		Type:             kind,
+		SubType:          subType,
+		Time:             t.UTC().Format(tidepoolTimeLayout),
+		DeviceTime:       deviceTime.Format(tidepoolDeviceTimeLayout),
+		TimezoneOffset:   offset / 60,
+		ConversionOffset: milliseconds(deviceTime.Sub(t)),
+		DeviceID:         c.deviceID,
+	}
+}
+
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func tidepoolInsulin(r Insulin) *float64 {
+	v := float64(r) / 1000
+	return &v
+}
+
+func tidepoolDuration(d time.Duration) *int64 {
+	v := milliseconds(d)
+	return &v
+}
+
+// tidepoolGlucose converts a glucose value and its units for Tidepool.
+func tidepoolGlucose(g Glucose, t GlucoseUnitsType) (float64, string) {
+	if t == MMolPerLiter {
+		// Glucose values in mmol/L are represented in μmol/L.
+		return float64(g) / 1000, "mmol/L"
+	}
+	return float64(g), "mg/dL"
+}
+
+// TidepoolHistory converts pump history and CGM records
+// (in reverse chronological order) to Tidepool data,
+// sorted in chronological order. Basal data is derived from BasalTimeline.
+// Records without timestamps are omitted, as are glucose values sent
+// to the pump by fakemeter (along with the BGCapture records they produce).
+func TidepoolHistory(records History, cgm CGMHistory, deviceID string) TidepoolData {
+	c := newTidepoolConverter(records, deviceID)
+	var data TidepoolData
+	boluses := c.boluses(records)
+	added := make(map[*TidepoolBolus]bool)
+	fromFakeMeter := fakeMeterFilter(records)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Time.IsZero() || fromFakeMeter(r) {
+			continue
+		}
+		switch r.Type() {
+		case Bolus:
+			if b := boluses[&records[i]]; b != nil && !added[b] {
+				data = append(data, *b)
+				added[b] = true
+			}
+		case BolusWizard, BolusWizard512:
+			w := c.wizard(r)
+			if b := followingBolus(records[:i], r.Time); b != nil {
+				w.Bolus = boluses[b]
+			}
+			data = append(data, w)
+		case BGCapture, BGReceived, BGReceived512:
+			subType := "manual"
+			if r.Type() != BGCapture {
+				subType = "linked"
+			}
+			g := r.Info.(GlucoseRecord)
+			v, units := tidepoolGlucose(g.Glucose, g.Units)
+			data = append(data, TidepoolGlucose{
+				TidepoolBase: c.base("smbg", subType, r.Time, r.PumpTime),
+				Units:        units,
+				Value:        v,
+			})
+		case NewTime:
+			if i+1 < len(records) && records[i+1].Type() == ChangeTime {
+				data = append(data, c.timeChange(records[i+1], r))
+			}
+		default:
+			if e := c.deviceEvent(r); e != nil {
+				data = append(data, *e)
+			}
+		}
+	}
+	for _, seg := range BasalTimeline(records) {
+		data = append(data, c.basal(seg))
+	}
+	for _, r := range cgm {
+		if r.Type != CGMGlucose || r.Time.IsZero() || r.Glucose == 0 {
+			continue
+		}
+		data = append(data, TidepoolGlucose{
+			TidepoolBase: c.base("cbg", "", c.normalTime(r.Time), r.Time),
+			Units:        "mg/dL",
+			Value:        float64(r.Glucose),
+		})
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].base().Time < data[j].base().Time
+	})
+	return data
+}
+
+// boluses converts the Bolus records, indexed by the record they came from.
+// The normal and square parts of a dual wave bolus, which are recorded
+// separately with the same timestamp, are combined.
+func (c tidepoolConverter) boluses(records History) map[*HistoryRecord]*TidepoolBolus {
+	boluses := make(map[*HistoryRecord]*TidepoolBolus)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := &records[i]
+		if r.Type() != Bolus || r.Time.IsZero() {
+			continue
+		}
+		b := &TidepoolBolus{TidepoolBase: c.base("bolus", "normal", r.Time, r.PumpTime)}
+		c.addBolus(b, r.Info.(BolusRecord))
+		boluses[r] = b
+		if i > 0 && records[i-1].Type() == Bolus && records[i-1].Time.Equal(r.Time) {
+			next := records[i-1].Info.(BolusRecord)
+			if (next.Duration != 0) != (b.Extended != nil) {
+				c.addBolus(b, next)
+				boluses[&records[i-1]] = b
+				i--
+			}
+		}
+		switch {
+		case b.Normal != nil && b.Extended != nil:
+			b.SubType = "dual/square"
+		case b.Extended != nil:
+			b.SubType = "square"
+		}
+	}
+	return boluses
+}
+
+func (c tidepoolConverter) addBolus(b *TidepoolBolus, info BolusRecord) {
+	interrupted := info.Amount < info.Programmed
+	if info.Duration == 0 {
+		b.Normal = tidepoolInsulin(info.Amount)
+		if interrupted {
+			b.ExpectedNormal = tidepoolInsulin(info.Programmed)
+		}
+		return
+	}
+	b.Extended = tidepoolInsulin(info.Amount)
+	d := time.Duration(info.Duration)
+	if !interrupted {
+		b.Duration = tidepoolDuration(d)
+		return
+	}
+	// An interrupted square wave bolus delivers
+	// its programmed rate for a shorter time.
+	if info.Programmed != 0 {
+		b.Duration = tidepoolDuration(time.Duration(float64(d) * float64(info.Amount) / float64(info.Programmed)))
+	}
+	b.ExpectedExtended = tidepoolInsulin(info.Programmed)
+	b.ExpectedDuration = tidepoolDuration(d)
+}
+
+func (c tidepoolConverter) wizard(r HistoryRecord) TidepoolWizard {
+	info := r.Info.(BolusWizardRecord)
+	low, units := tidepoolGlucose(info.TargetLow, info.GlucoseUnits)
+	high, _ := tidepoolGlucose(info.TargetHigh, info.GlucoseUnits)
+	sensitivity, _ := tidepoolGlucose(info.Sensitivity, info.GlucoseUnits)
+	w := TidepoolWizard{
+		TidepoolBase:       c.base("wizard", "", r.Time, r.PumpTime),
+		Units:              units,
+		CarbInput:          carbGrams(info.CarbInput, info.CarbUnits),
+		InsulinCarbRatio:   gramsPerUnit(info.CarbRatio, info.CarbUnits),
+		InsulinSensitivity: sensitivity,
+		BGTarget:           TidepoolBGTarget{Low: low, High: high},
+		InsulinOnBoard:     *tidepoolInsulin(info.Unabsorbed),
+		Recommended: TidepoolRecommended{
+			Carb:       *tidepoolInsulin(info.Food),
+			Correction: *tidepoolInsulin(info.Correction),
+			Net:        *tidepoolInsulin(info.Bolus),
+		},
+	}
+	if info.GlucoseInput != 0 {
+		bg, _ := tidepoolGlucose(info.GlucoseInput, info.GlucoseUnits)
+		w.BGInput = &bg
+	}
+	return w
+}
+
+var tidepoolAlarmTypes = map[AlarmCode]string{
+	NoDelivery:      "no_delivery",
+	BatteryDepleted: "no_power",
+	AutoOff:         "auto_off",
+	EmptyReservoir:  "no_insulin",
+}
+
+// deviceEvent converts records that correspond to Tidepool device events,
+// or returns nil.
+func (c tidepoolConverter) deviceEvent(r HistoryRecord) *TidepoolDeviceEvent {
+	e := TidepoolDeviceEvent{TidepoolBase: c.base("deviceEvent", "", r.Time, r.PumpTime)}
+	switch r.Type() {
+	case Prime:
+		info := r.Info.(PrimeRecord)
+		e.SubType = "prime"
+		if info.Fixed != 0 {
+			e.PrimeTarget = "cannula"
+			e.Volume = tidepoolInsulin(info.Fixed)
+		} else {
+			e.PrimeTarget = "tubing"
+			e.Volume = tidepoolInsulin(info.Manual)
+		}
+	case Rewind:
+		e.SubType = "reservoirChange"
+	case Alarm:
+		e.SubType = "alarm"
+		e.AlarmType = tidepoolAlarmTypes[r.Info.(AlarmCode)]
+		if e.AlarmType == "" {
+			e.AlarmType = "other"
+		}
+	case LowReservoir:
+		e.SubType = "alarm"
+		e.AlarmType = "low_insulin"
+	case LowBattery:
+		e.SubType = "alarm"
+		e.AlarmType = "low_power"
+	default:
+		return nil
+	}
+	return &e
+}
+
+// timeChange converts a ChangeTime record (with the old clock time)
+// and the NewTime record that follows it.
+func (c tidepoolConverter) timeChange(from HistoryRecord, to HistoryRecord) TidepoolDeviceEvent {
+	return TidepoolDeviceEvent{
+		TidepoolBase: c.base("deviceEvent", "timeChange", to.Time, to.PumpTime),
+		Change: &TidepoolTimeChange{
+			From:  recordTime(from).Format(tidepoolDeviceTimeLayout),
+			To:    recordTime(to).Format(tidepoolDeviceTimeLayout),
+			Agent: "manual",
+		},
+	}
+}
+
+var tidepoolDeliveryTypes = map[BasalReason]string{
+	BasalScheduled: "scheduled",
+	BasalTemp:      "temp",
+	BasalSuspended: "suspend",
+	BasalRewound:   "suspend",
+}
+
+func (c tidepoolConverter) basal(seg BasalSegment) TidepoolBasal {
+	b := TidepoolBasal{
+		TidepoolBase: c.base("basal", "", seg.Start, c.pumpTime(seg.Start)),
+		DeliveryType: tidepoolDeliveryTypes[seg.Reason],
+		Duration:     milliseconds(seg.End.Sub(seg.Start)),
+	}
+	if b.DeliveryType != "suspend" {
+		b.Rate = tidepoolInsulin(seg.Rate)
+	}
+	return b
+}
+
+// TidepoolSettings converts a settings backup to Tidepool pump settings,
+// timestamped with the time of the backup.
+func TidepoolSettings(b Backup, deviceID string) TidepoolPumpSettings {
+	c := tidepoolConverter{deviceID: deviceID}
+	s := TidepoolPumpSettings{
+		TidepoolBase:   c.base("pumpSettings", "", b.Time, b.Clock),
+		BasalSchedules: make(map[string][]TidepoolBasalEntry),
+		Units:          TidepoolUnits{Carb: "grams", BG: "mg/dL"},
+	}
+	if b.GlucoseUnits == MMolPerLiter {
+		s.Units.BG = "mmol/L"
+	}
+	for i, sched := range b.basalSchedules() {
+		name := tidepoolScheduleNames[i]
+		if i == b.Settings.SelectedPattern {
+			s.ActiveSchedule = name
+		}
+		entries := []TidepoolBasalEntry{}
+		for _, r := range sched {
+			entries = append(entries, TidepoolBasalEntry{
+				Start: milliseconds(time.Duration(r.Start)),
+				Rate:  *tidepoolInsulin(r.Rate),
+			})
+		}
+		s.BasalSchedules[name] = entries
+	}
+	for _, t := range b.Targets {
+		low, _ := tidepoolGlucose(t.Low, t.Units)
+		high, _ := tidepoolGlucose(t.High, t.Units)
+		s.BGTarget = append(s.BGTarget, TidepoolBGTargetEntry{
+			Start: milliseconds(time.Duration(t.Start)),
+			Low:   low,
+			High:  high,
+		})
+	}
+	for _, r := range b.CarbRatios {
+		s.CarbRatio = append(s.CarbRatio, TidepoolScheduleEntry{
+			Start:  milliseconds(time.Duration(r.Start)),
+			Amount: gramsPerUnit(r.Ratio, r.Units),
+		})
+	}
+	for _, r := range b.Sensitivities {
+		amount, _ := tidepoolGlucose(r.Sensitivity, r.Units)
+		s.InsulinSensitivity = append(s.InsulinSensitivity, TidepoolScheduleEntry{
+			Start:  milliseconds(time.Duration(r.Start)),
+			Amount: amount,
+		})
+	}
+	return s
+}
+
+// Save writes Tidepool data to a file as a JSON array,
+// the format accepted by the Tidepool uploader.
+func (data TidepoolData) Save(path string) error {
+	if data == nil {
+		data = TidepoolData{}
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,177 @@
+package medtronic
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDeviceID = "MedT-523-123456"
+
+func TestTidepoolHistoryData(t *testing.T) {
+	cases := []testCase{
+		{"pump-records", 523, 0},
+		{"records", 523, 0},
+		{"ps2", 554, 1},
+	}
+	for _, c := range cases {
+		testFile := testFileName(c)
+		t.Run(testFile, func(t *testing.T) {
+			records, err := decodeFromData(testFile+".json", testPumpFamily(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := TidepoolHistory(records, nil, testDeviceID)
+			eq, msg := compareDataToJSON(data, testFile+".tidepool")
+			if !eq {
+				t.Errorf("JSON is different:\n%v\n", msg)
+			}
+		})
+	}
+}
+
+func TestTidepoolHistory(t *testing.T) {
+	// NormalizeHistory converts times to UTC and keeps the pump's clock time.
+	normalized := testDose(Bolus, "2020-03-10T12:30:00", BolusRecord{Programmed: 1000, Amount: 500})
+	normalized.Time = normalized.Time.UTC()
+	normalized.PumpTime = parseTime("2020-03-10T12:25:00")
+	// Records in reverse chronological order.
+	records := History{
+		normalized,
+		testDose(Bolus, "2020-03-10T12:20:00", BolusRecord{Programmed: 2000, Amount: 1000, Duration: Duration(2 * time.Hour)}),
+		testDose(Bolus, "2020-03-10T12:10:00", BolusRecord{Programmed: 3000, Amount: 3000, Duration: Duration(time.Hour)}),
+		testDose(Bolus, "2020-03-10T12:10:00", BolusRecord{Programmed: 1000, Amount: 1000}),
+		testRecord(NewTime, "2020-03-10T12:00:00"),
+		testRecord(ChangeTime, "2020-03-10T11:00:00"),
+	}
+	cgm := CGMHistory{
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T12:15:00"), Glucose: 150},
+		{Type: CGMSync, Time: parseTime("2020-03-10T12:12:00")},
+	}
+	data := TidepoolHistory(records, cgm, testDeviceID)
+	type summary struct {
+		Type, SubType, Time, DeviceTime string
+		ConversionOffset                int64
+	}
+	want := []summary{
+		{"deviceEvent", "timeChange", "2020-03-10T16:00:00.000Z", "2020-03-10T12:00:00", 0},
+		{"bolus", "dual/square", "2020-03-10T16:10:00.000Z", "2020-03-10T12:10:00", 0},
+		{"bolus", "square", "2020-03-10T16:20:00.000Z", "2020-03-10T12:20:00", 0},
+		// CGM times are converted with the clock offset of the normalized record.
+		{"cbg", "", "2020-03-10T16:20:00.000Z", "2020-03-10T12:15:00", -300000},
+		{"bolus", "normal", "2020-03-10T16:30:00.000Z", "2020-03-10T12:25:00", -300000},
+	}
+	var got []summary
+	for _, d := range data {
+		b := d.base()
+		if b.DeviceID != testDeviceID || b.TimezoneOffset != -240 {
+			t.Errorf("%+v has wrong device ID or time zone offset", b)
+		}
+		got = append(got, summary{b.Type, b.SubType, b.Time, b.DeviceTime, b.ConversionOffset})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("TidepoolHistory = %+v, want %+v", got, want)
+	}
+	change := data[0].(TidepoolDeviceEvent).Change
+	if change == nil || change.From != "2020-03-10T11:00:00" || change.To != "2020-03-10T12:00:00" {
+		t.Errorf("time change = %+v", change)
+	}
+	boluses := []struct {
+		datum TidepoolBolus
+		want  string
+	}{
+		{data[1].(TidepoolBolus), `"normal":1,"extended":3,"duration":3600000`},
+		{data[2].(TidepoolBolus), `"extended":1,"expectedExtended":2,"duration":3600000,"expectedDuration":7200000`},
+		{data[4].(TidepoolBolus), `"normal":0.5,"expectedNormal":1`},
+	}
+	for _, b := range boluses {
+		j, err := json.Marshal(b.datum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(j), b.want) {
+			t.Errorf("%s does not contain %s", j, b.want)
+		}
+	}
+}
+
+func TestTidepoolNormalizedHistory(t *testing.T) {
+	// The pump's clock is 5 minutes slow.
+	normalized := func(r HistoryRecord) HistoryRecord {
+		r.PumpTime = r.Time
+		r.Time = r.Time.Add(5 * time.Minute).UTC()
+		return r
+	}
+	fakeMeter := GlucoseRecord{Units: MgPerDeciLiter, Glucose: 110, MeterID: fakeMeterID}
+	meter := GlucoseRecord{Units: MgPerDeciLiter, Glucose: 120, MeterID: "123456"}
+	// Records in reverse chronological order.
+	records := History{
+		normalized(testDose(BGReceived, "2020-03-10T13:00:00", meter)),
+		normalized(testDose(BGCapture, "2020-03-10T12:45:00", GlucoseRecord{Units: MgPerDeciLiter, Glucose: 110})),
+		normalized(testDose(BGReceived, "2020-03-10T12:45:00", fakeMeter)),
+		normalized(testDose(TempBasalDuration, "2020-03-10T12:30:00", Duration(30*time.Minute))),
+		normalized(testDose(TempBasalRate, "2020-03-10T12:30:00", TempBasalRecord{Type: Absolute, Value: Insulin(2000)})),
+		normalized(profileStart("2020-03-10T12:00:00", "12:00", 1000)),
+		normalized(testRecord(NewTime, "2020-03-10T11:00:00")),
+		normalized(testRecord(ChangeTime, "2020-03-10T10:00:00")),
+	}
+	cgm := CGMHistory{
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T12:15:00"), Glucose: 150},
+	}
+	data := TidepoolHistory(records, cgm, testDeviceID)
+	type summary struct {
+		Type, SubType, Time, DeviceTime string
+		TimezoneOffset                  int
+		ConversionOffset                int64
+	}
+	want := []summary{
+		{"deviceEvent", "timeChange", "2020-03-10T15:05:00.000Z", "2020-03-10T11:00:00", -240, -300000},
+		{"basal", "", "2020-03-10T16:05:00.000Z", "2020-03-10T12:00:00", -240, -300000},
+		{"cbg", "", "2020-03-10T16:20:00.000Z", "2020-03-10T12:15:00", -240, -300000},
+		{"basal", "", "2020-03-10T16:35:00.000Z", "2020-03-10T12:30:00", -240, -300000},
+		{"smbg", "linked", "2020-03-10T17:05:00.000Z", "2020-03-10T13:00:00", -240, -300000},
+	}
+	var got []summary
+	for _, d := range data {
+		b := d.base()
+		got = append(got, summary{b.Type, b.SubType, b.Time, b.DeviceTime, b.TimezoneOffset, b.ConversionOffset})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("TidepoolHistory = %+v, want %+v", got, want)
+	}
+	change := data[0].(TidepoolDeviceEvent).Change
+	if change == nil || change.From != "2020-03-10T10:00:00" || change.To != "2020-03-10T11:00:00" {
+		t.Errorf("time change = %+v", change)
+	}
+}
+
+func TestTidepoolSettings(t *testing.T) {
+	b := Backup{
+		Time:          parseTime("2020-03-10T12:00:00").UTC(),
+		Clock:         parseTime("2020-03-10T11:58:00"),
+		Settings:      SettingsInfo{SelectedPattern: 1},
+		GlucoseUnits:  MMolPerLiter,
+		BasalRates:    BasalRateSchedule{{Start: parseTD("00:00"), Rate: 800}},
+		BasalPatternA: BasalRateSchedule{{Start: parseTD("00:00"), Rate: 700}, {Start: parseTD("06:30"), Rate: 1050}},
+		CarbRatios:    CarbRatioSchedule{{Start: parseTD("00:00"), Ratio: 1500, Units: Exchanges}},
+		Sensitivities: InsulinSensitivitySchedule{{Start: parseTD("00:00"), Sensitivity: 2500, Units: MMolPerLiter}},
+		Targets:       GlucoseTargetSchedule{{Start: parseTD("00:00"), Low: 5500, High: 6500, Units: MMolPerLiter}},
+	}
+	s := TidepoolSettings(b, testDeviceID)
+	j, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"pumpSettings","time":"2020-03-10T16:00:00.000Z","deviceTime":"2020-03-10T11:58:00","timezoneOffset":-240,"conversionOffset":-120000,"deviceId":"MedT-523-123456",` +
+		`"activeSchedule":"pattern a",` +
+		`"basalSchedules":{"pattern a":[{"start":0,"rate":0.7},{"start":23400000,"rate":1.05}],"pattern b":[],"standard":[{"start":0,"rate":0.8}]},` +
+		`"bgTarget":[{"start":0,"low":5.5,"high":6.5}],` +
+		`"carbRatio":[{"start":0,"amount":10}],` +
+		`"insulinSensitivity":[{"start":0,"amount":2.5}],` +
+		`"units":{"carb":"grams","bg":"mmol/L"}}`
+	if string(j) != want {
+		t.Errorf("TidepoolSettings = %s, want %s", j, want)
+	}
+}
@@ -0,0 +1,133 @@
+package medtronic
+
+import (
+	"time"
+)
+
+const (
+	// Clock corrections no larger than this are attributed to drift
+	// that accumulated gradually since the clock was last set.
+	// Larger corrections (such as for DST or a reset clock)
+	// are treated as a step change.
+	maxClockDrift = 10 * time.Minute
+)
+
+// A clockSegment is a sequence of history records
+// (in reverse chronological order) that were timestamped
+// without any intervening change to the pump's clock.
+type clockSegment struct {
+	records History
+
+	// The segment ends with a correction from pump time c to true time n,
+	// either when the clock was changed or when it was compared with the host clock.
+	c time.Time
+	n time.Time
+
+	// Pump time at the start of the segment, or zero if unknown.
+	start time.Time
+}
+
+// splitClockSegments splits records (in reverse chronological order)
+// at each ChangeTime/NewTime pair.
+func splitClockSegments(records History, pumpClock, hostTime time.Time) []clockSegment {
+	segments := []clockSegment{{c: pumpClock, n: hostTime}}
+	lo := 0
+	for i := 1; i < len(records); i++ {
+		r, newer := records[i], records[i-1]
+		if r.Type() != ChangeTime || newer.Type() != NewTime {
+			continue
+		}
+		seg := &segments[len(segments)-1]
+		seg.records = records[lo:i]
+		seg.start = newer.Time
+		segments = append(segments, clockSegment{c: r.Time, n: newer.Time})
+		lo = i
+	}
+	segments[len(segments)-1].records = records[lo:]
+	return segments
+}
+
+// correction returns the function that maps pump times in the segment onto
+// the true timeline, given the base offset of the newer segment at n.
+func (seg clockSegment) correction(base time.Duration) func(time.Time) time.Time {
+	if seg.c.IsZero() {
+		return func(t time.Time) time.Time { return t.Add(base) }
+	}
+	delta := seg.n.Sub(seg.c)
+	start := seg.start
+	if start.IsZero() {
+		start = oldestTime(seg.records)
+	}
+	if abs(delta) > maxClockDrift || start.IsZero() || !seg.c.After(start) {
+		// Step change: the clock was off by delta throughout the segment.
+		return func(t time.Time) time.Time { return t.Add(base + delta) }
+	}
+	// Drift: the error grew linearly from zero at the start of the segment.
+	span := seg.c.Sub(start)
+	return func(t time.Time) time.Time {
+		drift := time.Duration(float64(delta) * float64(t.Sub(start)) / float64(span))
+		return t.Add(base + drift)
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func oldestTime(records History) time.Time {
+	var t time.Time
+	for _, r := range records {
+		if !r.Time.IsZero() && !isDailyTotal(r) && (t.IsZero() || r.Time.Before(t)) {
+			t = r.Time
+		}
+	}
+	return t
+}
+
+func isDailyTotal(r HistoryRecord) bool {
+	switch r.Type() {
+	case DailyTotal, DailyTotal515, DailyTotal522, DailyTotal523:
+		return true
+	}
+	return false
+}
+
+// NormalizeHistory returns a copy of records (in reverse chronological order)
+// with timestamps rewritten onto a consistent UTC timeline.
+// The original timestamp of each record is kept in its PumpTime field.
+//
+// The pump's clock is assumed to have been correct whenever it was set
+// (as recorded by ChangeTime/NewTime pairs), and pumpClock is the time
+// that it reported (from Pump.Clock) at hostTime.
+// Small corrections are spread linearly over the preceding interval
+// to account for drift; larger ones are applied as a step.
+// If pumpClock is zero, the current pump time is assumed to be correct.
+//
+// Daily total records are dated rather than timestamped,
+// so their Time is left unchanged.
+func NormalizeHistory(records History, pumpClock, hostTime time.Time) History {
+	results := make(History, len(records))
+	copy(results, records)
+	if pumpClock.IsZero() {
+		hostTime = time.Time{}
+	}
+	base := time.Duration(0)
+	i := 0
+	for _, seg := range splitClockSegments(results, pumpClock, hostTime) {
+		f := seg.correction(base)
+		for _, r := range seg.records {
+			if !r.Time.IsZero() && !isDailyTotal(r) {
+				results[i].PumpTime = r.Time
+				results[i].Time = f(r.Time).Round(time.Second).UTC()
+			}
+			i++
+		}
+		if !seg.start.IsZero() {
+			base = f(seg.start).Sub(seg.start)
+		}
+	}
+	return results
+}
@@ -0,0 +1,70 @@
+package medtronic
+
+import (
+	"testing"
+	"time"
+)
+
+func testRecord(t HistoryRecordType, ts string) HistoryRecord {
+	r := HistoryRecord{Data: []byte{byte(t)}}
+	if ts != "" {
+		r.Time = parseTime(ts)
+	}
+	return r
+}
+
+func TestNormalizeHistory(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testRecord(Bolus, "2020-03-10T12:00:00"),
+		testRecord(NewTime, "2020-03-10T10:30:00"),
+		// The clock was set back an hour.
+		testRecord(ChangeTime, "2020-03-10T11:30:00"),
+		testRecord(Bolus, "2020-03-10T11:00:00"),
+		testRecord(Bolus, ""),
+		testRecord(NewTime, "2020-03-10T09:00:00"),
+		// The clock had drifted 30 seconds slow since it was last set.
+		testRecord(ChangeTime, "2020-03-10T08:59:30"),
+		testRecord(Prime, "2020-03-10T07:59:45"),
+		// The clock was set forward an hour.
+		testRecord(NewTime, "2020-03-10T06:00:00"),
+		testRecord(ChangeTime, "2020-03-10T05:00:00"),
+		testRecord(Rewind, "2020-03-10T04:00:00"),
+	}
+	// The pump clock is now 10 seconds slow.
+	pumpClock := parseTime("2020-03-10T12:30:00")
+	hostTime := parseTime("2020-03-10T12:30:10")
+	want := []string{
+		// 10 seconds of drift spread over 2 hours.
+		"2020-03-10T12:00:08",
+		"2020-03-10T10:30:00",
+		// Step of 1 hour.
+		"2020-03-10T10:30:00",
+		"2020-03-10T10:00:00",
+		"",
+		"2020-03-10T08:00:00",
+		// 30 seconds of drift spread over 3 hours, plus the previous step.
+		"2020-03-10T08:00:00",
+		"2020-03-10T07:00:05",
+		"2020-03-10T05:00:00",
+		// Step of 1 hour in the opposite direction.
+		"2020-03-10T05:00:00",
+		"2020-03-10T04:00:00",
+	}
+	results := NormalizeHistory(records, pumpClock, hostTime)
+	for i, r := range results {
+		if want[i] == "" {
+			if !r.Time.IsZero() || !r.PumpTime.IsZero() {
+				t.Errorf("record %d: Time = %v, PumpTime = %v, want zero", i, r.Time, r.PumpTime)
+			}
+			continue
+		}
+		w := parseTime(want[i]).UTC()
+		if !r.Time.Equal(w) || r.Time.Location() != time.UTC {
+			t.Errorf("record %d (%v): normalized time %v, want %v", i, r.Type(), r.Time, w)
+		}
+		if !r.PumpTime.Equal(records[i].Time) {
+			t.Errorf("record %d (%v): pump time %v, want %v", i, r.Type(), r.PumpTime, records[i].Time)
+		}
+	}
+}
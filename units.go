@@ -20,6 +20,18 @@ const (
 	Exchanges CarbUnitsType = 2
 )
 
+// Grams of carbohydrate per exchange.
+const gramsPerExchange = 15
+
+// carbGrams converts a carb value to grams.
+func carbGrams(c Carbs, t CarbUnitsType) float64 {
+	if t == Exchanges {
+		// Exchanges are represented as 10x exchanges.
+		return float64(c) / 10 * gramsPerExchange
+	}
+	return float64(c)
+}
+
 // Glucose represents a glucose value as either mg/dL or μmol/L,
 // so all conversions must include a GlucoseUnitsType parameter.
 type Glucose int
@@ -75,6 +87,30 @@ func byteToGlucose(n byte, t GlucoseUnitsType) Glucose {
 	return intToGlucose(int(n), t)
 }
 
+func glucoseToInt(kind string, g Glucose, t GlucoseUnitsType) (int, error) {
+	switch t {
+	case MgPerDeciLiter:
+		return int(g), nil
+	case MMolPerLiter:
+		// Convert μmol/L to 10x mmol/L
+		n := (g + 50) / 100
+		if n*100 != g {
+			log.Printf("rounding %s from %d to %d", kind, g, n*100)
+		}
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("unknown glucose unit %d", t)
+	}
+}
+
+// formatGlucose returns a human-readable representation of a glucose value.
+func formatGlucose(g Glucose, t GlucoseUnitsType) string {
+	if t == MMolPerLiter {
+		return fmt.Sprintf("%.1f mmol/L", float64(g)/1000)
+	}
+	return fmt.Sprintf("%d mg/dL", g)
+}
+
 // CarbUnits returns the pump's carb units.
 func (pump *Pump) CarbUnits() CarbUnitsType {
 	return CarbUnitsType(pump.whichUnits(carbUnits))
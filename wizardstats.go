@@ -0,0 +1,270 @@
+package medtronic
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// The pump records the bolus immediately after the wizard calculation,
+	// so a bolus more than this long afterward is not attributed to it.
+	wizardBolusWindow = time.Minute
+
+	// Glucose outcomes are measured over this interval after a bolus.
+	outcomeStart = 2 * time.Hour
+	outcomeEnd   = 4 * time.Hour
+
+	// Conversion factor from mmol/L to mg/dL.
+	mgPerDeciLiterPerMMol = 18.0182
+)
+
+// WizardOverride describes how the delivered bolus differed
+// from the bolus recommended by the wizard.
+type WizardOverride string
+
+// Possible values for WizardUse.Override.
+const (
+	WizardAccepted  WizardOverride = "accepted"
+	WizardIncreased WizardOverride = "increased"
+	WizardDecreased WizardOverride = "decreased"
+	WizardCancelled WizardOverride = "cancelled"
+)
+
+// WizardUse pairs a bolus wizard calculation with the bolus that followed it.
+// Bolus is nil if no bolus was programmed.
+// Difference is the programmed amount minus the recommended amount.
+type WizardUse struct {
+	Time       time.Time
+	Wizard     BolusWizardRecord
+	Bolus      *BolusRecord `json:",omitempty"`
+	Override   WizardOverride
+	Difference Insulin
+	Outcome    *GlucoseOutcome `json:",omitempty"`
+}
+
+// GlucoseOutcome summarizes the sensor glucose readings (in mg/dL)
+// from 2 to 4 hours after a bolus, compared with the wizard's target range.
+type GlucoseOutcome struct {
+	Readings int
+	Mean     int
+	Min      int
+	Max      int
+	Result   string // "low", "in range", or "high"
+}
+
+// Programmed returns the amount of insulin programmed,
+// or 0 if no bolus followed the wizard calculation.
+func (u WizardUse) Programmed() Insulin {
+	if u.Bolus == nil {
+		return 0
+	}
+	return u.Bolus.Programmed
+}
+
+// WizardUses pairs each BolusWizard or BolusWizard512 record in the given
+// history records with the Bolus record that followed it, and measures the
+// glucose outcome from the given CGM records (both in reverse chronological order).
+// The CGM records may be nil if the pump has no sensor.
+// The results are in chronological order.
+func WizardUses(records History, cgm CGMHistory) []WizardUse {
+	var uses []WizardUse
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		t := r.Type()
+		if (t != BolusWizard && t != BolusWizard512) || r.Time.IsZero() {
+			continue
+		}
+		u := WizardUse{Time: r.Time, Wizard: r.Info.(BolusWizardRecord)}
+		if b := followingBolus(records[:i], r.Time); b != nil {
+			info := b.Info.(BolusRecord)
+			u.Bolus = &info
+		}
+		u.Difference = u.Programmed() - u.Wizard.Bolus
+		switch {
+		case u.Bolus == nil && u.Wizard.Bolus > 0:
+			u.Override = WizardCancelled
+		case u.Difference > 0:
+			u.Override = WizardIncreased
+		case u.Difference < 0:
+			u.Override = WizardDecreased
+		default:
+			u.Override = WizardAccepted
+		}
+		if u.Bolus != nil {
+			u.Outcome = glucoseOutcome(cgm, r.Time, u.Wizard)
+		}
+		uses = append(uses, u)
+	}
+	return uses
+}
+
+// followingBolus returns the Bolus record that immediately follows
+// a wizard calculation at time t, or nil if there is none.
+// The records are the ones after the wizard record, in reverse chronological order.
+func followingBolus(records History, t time.Time) *HistoryRecord {
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Time.IsZero() {
+			continue
+		}
+		if r.Time.Sub(t) > wizardBolusWindow {
+			break
+		}
+		switch r.Type() {
+		case Bolus:
+			return &records[i]
+		case BolusWizard, BolusWizard512:
+			return nil
+		}
+	}
+	return nil
+}
+
+// glucoseOutcome summarizes the sensor glucose readings
+// in the outcome interval after time t.
+func glucoseOutcome(cgm CGMHistory, t time.Time, w BolusWizardRecord) *GlucoseOutcome {
+	start, end := t.Add(outcomeStart), t.Add(outcomeEnd)
+	o := GlucoseOutcome{Min: math.MaxInt32}
+	sum := 0
+	for _, r := range cgm {
+		switch r.Type {
+		case CGMGlucose, CGMDataLow, CGMDataHigh:
+		default:
+			continue
+		}
+		if r.Time.Before(start) || r.Time.After(end) {
+			continue
+		}
+		o.Readings++
+		sum += r.Glucose
+		if r.Glucose < o.Min {
+			o.Min = r.Glucose
+		}
+		if r.Glucose > o.Max {
+			o.Max = r.Glucose
+		}
+	}
+	if o.Readings == 0 {
+		return nil
+	}
+	o.Mean = int(math.Round(float64(sum) / float64(o.Readings)))
+	switch {
+	case o.Mean < mgPerDeciLiter(w.TargetLow, w.GlucoseUnits):
+		o.Result = "low"
+	case o.Mean > mgPerDeciLiter(w.TargetHigh, w.GlucoseUnits):
+		o.Result = "high"
+	default:
+		o.Result = "in range"
+	}
+	return &o
+}
+
+// mgPerDeciLiter converts a glucose value to mg/dL.
+func mgPerDeciLiter(g Glucose, t GlucoseUnitsType) int {
+	if t == MMolPerLiter {
+		// Glucose values in mmol/L are represented in μmol/L.
+		return int(math.Round(float64(g) / 1000 * mgPerDeciLiterPerMMol))
+	}
+	return int(g)
+}
+
+// WizardSummary summarizes bolus wizard usage during part of the day.
+type WizardSummary struct {
+	Start TimeOfDay
+	Uses  int
+
+	Accepted  int
+	Increased int
+	Decreased int
+	Cancelled int
+
+	// Totals recommended by the wizard and actually programmed.
+	Correction  Insulin
+	Food        Insulin
+	Recommended Insulin
+	Programmed  Insulin
+
+	// Glucose outcomes, for uses with sensor readings afterward.
+	Outcomes int
+	Low      int
+	InRange  int
+	High     int
+}
+
+// OverrideRate returns the fraction of wizard uses that were overridden.
+func (s WizardSummary) OverrideRate() float64 {
+	if s.Uses == 0 {
+		return 0
+	}
+	return float64(s.Uses-s.Accepted) / float64(s.Uses)
+}
+
+// SummarizeWizardUses groups wizard uses by time of day.
+// Each period begins at one of the given times of day (in increasing order)
+// and lasts until the next; a period beginning at midnight is added if needed.
+func SummarizeWizardUses(uses []WizardUse, starts []TimeOfDay) []WizardSummary {
+	if len(starts) == 0 || starts[0] != 0 {
+		starts = append([]TimeOfDay{0}, starts...)
+	}
+	summaries := make([]WizardSummary, len(starts))
+	for i, start := range starts {
+		summaries[i].Start = start
+	}
+	for _, u := range uses {
+		t := SinceMidnight(u.Time)
+		i := len(starts) - 1
+		for i > 0 && starts[i] > t {
+			i--
+		}
+		summaries[i].add(u)
+	}
+	return summaries
+}
+
+func (s *WizardSummary) add(u WizardUse) {
+	s.Uses++
+	switch u.Override {
+	case WizardAccepted:
+		s.Accepted++
+	case WizardIncreased:
+		s.Increased++
+	case WizardDecreased:
+		s.Decreased++
+	case WizardCancelled:
+		s.Cancelled++
+	}
+	s.Correction += u.Wizard.Correction
+	s.Food += u.Wizard.Food
+	s.Recommended += u.Wizard.Bolus
+	s.Programmed += u.Programmed()
+	if u.Outcome == nil {
+		return
+	}
+	s.Outcomes++
+	switch u.Outcome.Result {
+	case "low":
+		s.Low++
+	case "high":
+		s.High++
+	default:
+		s.InRange++
+	}
+}
+
+// WizardUses retrieves pump history (and CGM history,
+// for pumps that support a sensor) since the given time,
+// and pairs each bolus wizard calculation with the bolus that followed it.
+func (pump *Pump) WizardUses(since time.Time) []WizardUse {
+	records := pump.History(since)
+	if pump.Error() != nil {
+		return nil
+	}
+	var cgm CGMHistory
+	if pump.Family() >= 22 {
+		cgm = pump.CGMHistory(since)
+		if pump.Error() != nil {
+			return nil
+		}
+	}
+	return WizardUses(records, cgm)
+}
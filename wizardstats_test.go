@@ -0,0 +1,168 @@
+package medtronic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWizardUses(t *testing.T) {
+	wizard := func(ts string, correction, food, bolus Insulin) HistoryRecord {
+		return testDose(BolusWizard, ts, BolusWizardRecord{
+			GlucoseUnits: MgPerDeciLiter,
+			TargetLow:    90,
+			TargetHigh:   120,
+			Correction:   correction,
+			Food:         food,
+			Bolus:        bolus,
+		})
+	}
+	bolus := func(ts string, amount Insulin) HistoryRecord {
+		return testDose(Bolus, ts, BolusRecord{Programmed: amount, Amount: amount})
+	}
+	// Records in reverse chronological order.
+	records := History{
+		bolus("2020-03-10T19:00:00", 1000),
+		// Cancelled.
+		wizard("2020-03-10T18:00:00", 0, 3000, 3000),
+		bolus("2020-03-10T12:00:00", 4000),
+		wizard("2020-03-10T12:00:00", 500, 3000, 3500),
+		bolus("2020-03-10T07:00:00", 2000),
+		wizard("2020-03-10T07:00:00", 1000, 2000, 3000),
+	}
+	cgm := CGMHistory{
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T15:30:00"), Glucose: 70},
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T14:30:00"), Glucose: 80},
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T10:00:00"), Glucose: 150},
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T09:30:00"), Glucose: 110},
+		{Type: CGMCalBG, Time: parseTime("2020-03-10T09:15:00"), Glucose: 300},
+		// Too soon after the bolus.
+		{Type: CGMGlucose, Time: parseTime("2020-03-10T08:00:00"), Glucose: 250},
+	}
+	uses := WizardUses(records, cgm)
+	overrides := []WizardOverride{WizardDecreased, WizardIncreased, WizardCancelled}
+	differences := []Insulin{-1000, 500, -3000}
+	outcomes := []*GlucoseOutcome{
+		{Readings: 2, Mean: 130, Min: 110, Max: 150, Result: "high"},
+		{Readings: 2, Mean: 75, Min: 70, Max: 80, Result: "low"},
+		nil,
+	}
+	if len(uses) != len(overrides) {
+		t.Fatalf("WizardUses() returned %d uses, want %d", len(uses), len(overrides))
+	}
+	for i, u := range uses {
+		if u.Override != overrides[i] {
+			t.Errorf("use %d: override = %s, want %s", i, u.Override, overrides[i])
+		}
+		if u.Difference != differences[i] {
+			t.Errorf("use %d: difference = %v, want %v", i, u.Difference, differences[i])
+		}
+		if !reflect.DeepEqual(u.Outcome, outcomes[i]) {
+			t.Errorf("use %d: outcome = %+v, want %+v", i, u.Outcome, outcomes[i])
+		}
+	}
+
+	starts := []TimeOfDay{TimeOfDay(6 * time.Hour), TimeOfDay(11 * time.Hour), TimeOfDay(17 * time.Hour)}
+	summaries := SummarizeWizardUses(uses, starts)
+	want := []WizardSummary{
+		{Start: 0},
+		{
+			Start:       TimeOfDay(6 * time.Hour),
+			Uses:        1,
+			Decreased:   1,
+			Correction:  1000,
+			Food:        2000,
+			Recommended: 3000,
+			Programmed:  2000,
+			Outcomes:    1,
+			High:        1,
+		},
+		{
+			Start:       TimeOfDay(11 * time.Hour),
+			Uses:        1,
+			Increased:   1,
+			Correction:  500,
+			Food:        3000,
+			Recommended: 3500,
+			Programmed:  4000,
+			Outcomes:    1,
+			Low:         1,
+		},
+		{
+			Start:       TimeOfDay(17 * time.Hour),
+			Uses:        1,
+			Cancelled:   1,
+			Food:        3000,
+			Recommended: 3000,
+		},
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("SummarizeWizardUses() = %+v, want %+v", summaries, want)
+	}
+}
+
+func TestWizardUses512(t *testing.T) {
+	// Records in reverse chronological order.
+	records := History{
+		testDose(Bolus, "2020-03-10T12:02:00", BolusRecord{Programmed: 2000, Amount: 2000}),
+		testDose(BolusWizard512, "2020-03-10T12:01:00", BolusWizardRecord{Bolus: 2000}),
+		// Superseded by the next calculation.
+		testDose(BolusWizard, "2020-03-10T12:00:00", BolusWizardRecord{Bolus: 1000}),
+	}
+	uses := WizardUses(records, nil)
+	overrides := []WizardOverride{WizardCancelled, WizardAccepted}
+	if len(uses) != len(overrides) {
+		t.Fatalf("WizardUses() returned %d uses, want %d", len(uses), len(overrides))
+	}
+	for i, u := range uses {
+		if u.Override != overrides[i] {
+			t.Errorf("use %d: override = %s, want %s", i, u.Override, overrides[i])
+		}
+	}
+}
+
+func TestMgPerDeciLiter(t *testing.T) {
+	cases := []struct {
+		g     Glucose
+		units GlucoseUnitsType
+		mgdl  int
+	}{
+		{100, MgPerDeciLiter, 100},
+		{5500, MMolPerLiter, 99},
+		{10000, MMolPerLiter, 180},
+	}
+	for _, c := range cases {
+		if n := mgPerDeciLiter(c.g, c.units); n != c.mgdl {
+			t.Errorf("mgPerDeciLiter(%d, %v) = %d, want %d", c.g, c.units, n, c.mgdl)
+		}
+	}
+}
+
+func TestWizardUsesHistory(t *testing.T) {
+	tc := testCase{"ps2", 554, 1}
+	records, err := decodeFromData(testFileName(tc)+".json", testPumpFamily(tc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uses := WizardUses(records, nil)
+	want := []WizardOverride{
+		WizardAccepted,
+		WizardIncreased,
+		WizardIncreased,
+		WizardAccepted,
+		WizardAccepted,
+		WizardAccepted,
+		WizardAccepted,
+	}
+	if len(uses) != len(want) {
+		t.Fatalf("WizardUses() returned %d uses, want %d", len(uses), len(want))
+	}
+	for i, u := range uses {
+		if u.Override != want[i] {
+			t.Errorf("use %d: override = %s, want %s", i, u.Override, want[i])
+		}
+		if i > 0 && u.Time.Before(uses[i-1].Time) {
+			t.Errorf("use %d is out of order: %+v", i, u)
+		}
+	}
+}